@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// AdminUser is a user as seen by admins, with the account state that the
+// user themselves doesn't get to see.
+type AdminUser struct {
+	ID               uuid.UUID  `json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Email            string     `json:"email"`
+	IsChirpyRed      bool       `json:"is_chirpy_red"`
+	Role             string     `json:"role"`
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	SuspendedAt      *time.Time `json:"suspended_at"`
+}
+
+func adminUserDBToJSON(userDB database.User, isChirpyRed bool) AdminUser {
+	user := AdminUser{
+		ID:               userDB.ID,
+		CreatedAt:        userDB.CreatedAt,
+		UpdatedAt:        userDB.UpdatedAt,
+		Email:            userDB.Email,
+		IsChirpyRed:      isChirpyRed,
+		Role:             userDB.Role,
+		TwoFactorEnabled: userDB.TotpEnabled,
+	}
+	if userDB.SuspendedAt.Valid {
+		user.SuspendedAt = &userDB.SuspendedAt.Time
+	}
+	return user
+}
+
+func (cfg *apiConfig) respondWithAdminUser(w http.ResponseWriter, r *http.Request, userDB database.User) {
+	isRed, err := cfg.isChirpyRed(r.Context(), userDB.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check subscription.", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, adminUserDBToJSON(userDB, isRed))
+}
+
+// adminListUsers lists users, optionally only those whose email contains
+// the q query parameter.
+func (cfg *apiConfig) adminListUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	usersInDB, err := cfg.db.ListUsers(r.Context(), database.ListUsersParams{
+		Search: r.URL.Query().Get("q"),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get users, database error.", err)
+		return
+	}
+
+	users := make([]AdminUser, len(usersInDB))
+	for i, row := range usersInDB {
+		users[i] = adminUserDBToJSON(row.User, row.IsChirpyRed)
+	}
+	respondWithJSON(w, http.StatusOK, users)
+}
+
+func (cfg *apiConfig) adminGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't get user, wrong UUID.", err)
+		return
+	}
+
+	userDB, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+	cfg.respondWithAdminUser(w, r, userDB)
+}
+
+// adminSuspendUser locks a user out: they can't log in and every session
+// and token they hold stops working.
+func (cfg *apiConfig) adminSuspendUser(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't suspend user, wrong UUID.", err)
+		return
+	}
+	if userID == principal.UserID {
+		respondWithError(w, http.StatusBadRequest, "Can't suspend yourself.", nil)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't suspend user, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userDB, err := qtx.SuspendUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+
+	err = revokeAllSessionsTx(r.Context(), qtx, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions.", err)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:  "admin.user_suspended",
+		ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:  userID.String(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't suspend user, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't suspend user, database error.", err)
+		return
+	}
+
+	err = cfg.denylist.RevokeUserBefore(r.Context(), userID, time.Now())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke old tokens.", err)
+		return
+	}
+
+	cfg.respondWithAdminUser(w, r, userDB)
+}
+
+func (cfg *apiConfig) adminUnsuspendUser(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't unsuspend user, wrong UUID.", err)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unsuspend user, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userDB, err := qtx.UnsuspendUser(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:  "admin.user_unsuspended",
+		ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:  userID.String(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unsuspend user, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unsuspend user, database error.", err)
+		return
+	}
+
+	cfg.respondWithAdminUser(w, r, userDB)
+}
+
+// adminLogoutUser ends every session of a user without suspending them.
+func (cfg *apiConfig) adminLogoutUser(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't log out user, wrong UUID.", err)
+		return
+	}
+
+	_, err = cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+
+	err = cfg.revokeAllSessions(r, userID, auditEvent{
+		Action:  "admin.user_logged_out",
+		ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:  userID.String(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// adminSetChirpyRed grants or takes away Chirpy Red by hand, for support
+// cases that Polka doesn't cover. Revoking cancels the subscription
+// whatever its source.
+func (cfg *apiConfig) adminSetChirpyRed(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't update user, wrong UUID.", err)
+		return
+	}
+
+	type parameters struct {
+		IsChirpyRed *bool `json:"is_chirpy_red"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil || params.IsChirpyRed == nil {
+		respondWithError(w, http.StatusBadRequest, "Can't update user, is_chirpy_red missing.", err)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userDB, err := qtx.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+
+	if *params.IsChirpyRed {
+		// An admin grant doesn't lapse; it lasts until it is revoked.
+		_, err = qtx.UpsertSubscription(r.Context(), database.UpsertSubscriptionParams{
+			UserID:             userID,
+			Plan:               planChirpyRed,
+			Source:             subscriptionSourceAdmin,
+			CurrentPeriodStart: time.Now().UTC(),
+		})
+		if err == nil {
+			err = publishEventTx(r.Context(), qtx, eventUserUpgraded, userID, map[string]any{
+				"user_id": userID,
+				"plan":    planChirpyRed,
+				"source":  subscriptionSourceAdmin,
+			})
+		}
+	} else {
+		_, err = qtx.CancelSubscription(r.Context(), database.CancelSubscriptionParams{
+			UserID: userID,
+			Plan:   planChirpyRed,
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update subscription, database error.", err)
+		return
+	}
+
+	action := "admin.red_revoked"
+	if *params.IsChirpyRed {
+		action = "admin.red_granted"
+	}
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:  action,
+		ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:  userID.String(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user, database error.", err)
+		return
+	}
+
+	cfg.respondWithAdminUser(w, r, userDB)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+type auditEvent struct {
+	Action   string
+	ActorID  uuid.NullUUID
+	Target   string
+	Metadata map[string]string
+}
+
+// recordAudit appends ev to the audit log on its own. Events that describe
+// a change to the database go through recordAuditTx instead.
+func (cfg *apiConfig) recordAudit(r *http.Request, ev auditEvent) {
+	err := recordAuditTx(cfg.db, r, ev)
+	if err != nil {
+		log.Printf("Error recording audit event %s for %v: %s", ev.Action, ev.ActorID.UUID, err)
+	}
+}
+
+// recordAuditTx appends ev using q, so that when q is bound to a
+// transaction the event is committed or rolled back with the change.
+func recordAuditTx(q *database.Queries, r *http.Request, ev auditEvent) error {
+	return writeAudit(r.Context(), q, clientIP(r), r.UserAgent(), ev)
+}
+
+// recordSystemAuditTx is recordAuditTx for events that no request caused,
+// such as those of background jobs.
+func recordSystemAuditTx(ctx context.Context, q *database.Queries, ev auditEvent) error {
+	return writeAudit(ctx, q, "", "chirpy", ev)
+}
+
+func writeAudit(ctx context.Context, q *database.Queries, ip, userAgent string, ev auditEvent) error {
+	if ev.Metadata == nil {
+		ev.Metadata = map[string]string{}
+	}
+	metadata, err := json.Marshal(ev.Metadata)
+	if err != nil {
+		return err
+	}
+	return q.CreateAuditEvent(ctx, database.CreateAuditEventParams{
+		ActorID:   ev.ActorID,
+		Action:    ev.Action,
+		Target:    nullString(ev.Target),
+		IpAddress: ip,
+		UserAgent: userAgent,
+		Metadata:  metadata,
+	})
+}
+
+type AuditEvent struct {
+	ID        uuid.UUID       `json:"id"`
+	CreatedAt time.Time       `json:"created_at"`
+	ActorID   *uuid.UUID      `json:"actor_id"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target,omitempty"`
+	IPAddress string          `json:"ip_address"`
+	UserAgent string          `json:"user_agent"`
+	Metadata  json.RawMessage `json:"metadata"`
+}
+
+// adminListAuditEvents returns the audit log, newest first. It can be
+// narrowed down by actor_id, action, target and a since/until time range
+// in RFC 3339.
+func (cfg *apiConfig) adminListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	query := r.URL.Query()
+	params := database.ListAuditEventsParams{
+		Action: nullString(query.Get("action")),
+		Target: nullString(query.Get("target")),
+		Limit:  limit,
+		Offset: offset,
+	}
+	if s := query.Get("actor_id"); s != "" {
+		actorID, err := uuid.Parse(s)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't get audit events, wrong actor UUID.", err)
+			return
+		}
+		params.ActorID = uuid.NullUUID{UUID: actorID, Valid: true}
+	}
+	params.Since, err = nullTimeParam(query.Get("since"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't get audit events, since isn't an RFC 3339 time.", err)
+		return
+	}
+	params.Until, err = nullTimeParam(query.Get("until"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't get audit events, until isn't an RFC 3339 time.", err)
+		return
+	}
+
+	eventsInDB, err := cfg.db.ListAuditEvents(r.Context(), params)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get audit events, database error.", err)
+		return
+	}
+
+	events := make([]AuditEvent, len(eventsInDB))
+	for i, eventDB := range eventsInDB {
+		events[i] = AuditEvent{
+			ID:        eventDB.ID,
+			CreatedAt: eventDB.CreatedAt,
+			Action:    eventDB.Action,
+			Target:    eventDB.Target.String,
+			IPAddress: eventDB.IpAddress,
+			UserAgent: eventDB.UserAgent,
+			Metadata:  eventDB.Metadata,
+		}
+		if eventDB.ActorID.Valid {
+			events[i].ActorID = &eventDB.ActorID.UUID
+		}
+	}
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+func nullTimeParam(s string) (sql.NullTime, error) {
+	if s == "" {
+		return sql.NullTime{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	// Timestamps are stored without a zone and written in UTC.
+	return sql.NullTime{Time: t.UTC(), Valid: true}, nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/outbox"
+	"github.com/romusking/chirpy/internal/stream"
+)
+
+const (
+	chirpStreamHeartbeat = 15 * time.Second
+	// chirpStreamBuffer is how many events a client may fall behind
+	// before it is disconnected.
+	chirpStreamBuffer = 64
+	// maxChirpStreamReplay bounds how many missed events are sent to a
+	// client resuming with Last-Event-ID.
+	maxChirpStreamReplay  = 1000
+	chirpStreamReplayPage = 100
+)
+
+// chirpStreamEventTypes are the events sent over server-sent events.
+// chirp.published is sent as chirp.created.
+var chirpStreamEventTypes = []string{eventChirpCreated, eventChirpPublished, eventChirpDeleted}
+
+var chirpFeedEventTypes = []string{eventChirpCreated, eventChirpUpdated, eventChirpPublished, eventChirpDeleted}
+
+// chirpPublishedNamespace derives the id of a chirp's chirp.published
+// event from the chirp's id.
+var chirpPublishedNamespace = uuid.MustParse("5b0c8f4e-3f1a-4d7e-9a52-6c1d2e8b7f30")
+
+// chirpFeed follows the outbox on this instance and publishes chirp
+// events to the streams and sockets connected to it. Every instance runs
+// its own feed, so a chirp written through any of them reaches every
+// client.
+type chirpFeed struct {
+	db  *database.Queries
+	hub *stream.Hub
+}
+
+func newChirpFeed(db *database.Queries) *chirpFeed {
+	return &chirpFeed{
+		db:  db,
+		hub: stream.NewHub(chirpStreamBuffer),
+	}
+}
+
+// run publishes the events whose seqs arrive on seqs until ctx is done.
+// Notifications come in commit order, which isn't always seq order, so
+// every notified event is published. After a reconnect, signalled by a 0,
+// the feed catches up from the highest seq it saw. Without seqs it polls.
+func (f *chirpFeed) run(ctx context.Context, seqs <-chan int64, pollInterval time.Duration) {
+	last, err := f.db.LatestDomainEventSeq(ctx)
+	if err != nil {
+		log.Printf("Error starting chirp feed: %s", err)
+	}
+
+	var poll <-chan time.Time
+	if seqs == nil {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		var seq int64
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll:
+		case seq = <-seqs:
+		}
+
+		if seq == 0 {
+			last, err = f.catchUp(ctx, last)
+			if err != nil {
+				log.Printf("Error catching up chirp feed: %s", err)
+			}
+			continue
+		}
+		eventDB, err := f.db.GetDomainEventBySeq(ctx, seq)
+		if err != nil {
+			log.Printf("Error reading domain event %d: %s", seq, err)
+			continue
+		}
+		last = max(last, seq)
+		if slices.Contains(chirpFeedEventTypes, eventDB.Type) {
+			f.publish(domainEventDBToOutbox(eventDB))
+		}
+	}
+}
+
+func (f *chirpFeed) catchUp(ctx context.Context, last int64) (int64, error) {
+	for {
+		eventsInDB, err := f.db.ListDomainEventsAfter(ctx, database.ListDomainEventsAfterParams{
+			After: last,
+			Types: chirpFeedEventTypes,
+			Limit: chirpStreamReplayPage,
+		})
+		if err != nil {
+			return last, err
+		}
+		for _, eventDB := range eventsInDB {
+			last = eventDB.Seq
+			f.publish(domainEventDBToOutbox(eventDB))
+		}
+		if len(eventsInDB) < chirpStreamReplayPage {
+			return last, nil
+		}
+	}
+}
+
+func (f *chirpFeed) publish(ev outbox.Event) {
+	ev, ok := visibleChirpEvent(ev)
+	if !ok {
+		return
+	}
+	f.hub.Publish(ev)
+}
+
+// visibleChirpEvent returns ev as clients see it, and whether they see it
+// at all. Clients hear nothing about a scheduled chirp until its
+// chirp.published event, which they get as chirp.created, so that the
+// chirp is announced at its own position in the outbox.
+func visibleChirpEvent(ev outbox.Event) (outbox.Event, bool) {
+	if ev.Type == eventChirpPublished {
+		ev.Type = eventChirpCreated
+		return ev, true
+	}
+	var chirp struct {
+		PublishedAt time.Time `json:"published_at"`
+	}
+	err := json.Unmarshal(ev.Payload, &chirp)
+	if err != nil {
+		log.Printf("Error reading domain event %d: %s", ev.Seq, err)
+		return ev, false
+	}
+	return ev, !chirp.PublishedAt.After(ev.OccurredAt)
+}
+
+// announceChirp is the outbox subscriber that writes chirp.published once
+// a scheduled chirp is published, with the chirp as it is by then. The
+// event's id is derived from the chirp's, so a chirp is announced once
+// however often this runs.
+func (cfg *apiConfig) announceChirp(ctx context.Context, ev outbox.Event) error {
+	chirp := Chirp{}
+	err := json.Unmarshal(ev.Payload, &chirp)
+	if err != nil {
+		return err
+	}
+	if !chirp.PublishedAt.After(ev.OccurredAt) {
+		return nil
+	}
+	if chirp.PublishedAt.After(time.Now()) {
+		return &outbox.DeferError{Until: chirp.PublishedAt}
+	}
+
+	chirpDB, err := cfg.db.GetOneChirp(ctx, chirp.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	id := uuid.NewSHA1(chirpPublishedNamespace, chirpDB.ID[:])
+	return publishEventIDTx(ctx, cfg.db, id, eventChirpPublished, chirpDB.UserID, chirpDBToChirpJSON(chirpDB))
+}
+
+func domainEventDBToOutbox(eventDB database.DomainEvent) outbox.Event {
+	return outbox.Event{
+		ID:         eventDB.ID,
+		Seq:        eventDB.Seq,
+		Type:       eventDB.Type,
+		UserID:     eventDB.UserID,
+		OccurredAt: eventDB.OccurredAt,
+		Payload:    eventDB.Payload,
+		Attempts:   int(eventDB.Attempts),
+	}
+}
+
+// streamChirps pushes chirp.created and chirp.deleted events as
+// server-sent events, optionally only those of the author_id query
+// parameter. The event id is the outbox seq, so a client that reconnects
+// with Last-Event-ID is first sent what it missed.
+func (cfg *apiConfig) streamChirps(w http.ResponseWriter, r *http.Request) {
+	var authorID uuid.NullUUID
+	if author := r.URL.Query().Get("author_id"); author != "" {
+		id, err := uuid.Parse(author)
+		if err != nil {
+			respondWithError(w, http.StatusNotFound, "Couldn't get chirps, autor unknown.", err)
+			return
+		}
+		authorID = uuid.NullUUID{UUID: id, Valid: true}
+	}
+
+	var lastEventID int64
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID.", err)
+			return
+		}
+		lastEventID = n
+	}
+
+	// Subscribing before replaying means nothing is lost in between;
+	// events sent by both are skipped the second time.
+	sub := cfg.chirpFeed.hub.Subscribe()
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	matches := func(ev outbox.Event) bool {
+		return !authorID.Valid || ev.UserID == authorID.UUID
+	}
+
+	replayed := map[int64]bool{}
+	if lastEventID > 0 {
+		after := lastEventID
+		for n := 0; n < maxChirpStreamReplay; n += chirpStreamReplayPage {
+			eventsInDB, err := cfg.db.ListDomainEventsAfter(r.Context(), database.ListDomainEventsAfterParams{
+				After:  after,
+				Types:  chirpStreamEventTypes,
+				UserID: authorID,
+				Limit:  chirpStreamReplayPage,
+			})
+			if err != nil {
+				log.Printf("Error replaying chirp stream: %s", err)
+				return
+			}
+			for _, eventDB := range eventsInDB {
+				after = eventDB.Seq
+				ev, ok := visibleChirpEvent(domainEventDBToOutbox(eventDB))
+				if !ok {
+					continue
+				}
+				err = writeChirpStreamEvent(w, ev)
+				if err != nil {
+					return
+				}
+				replayed[ev.Seq] = true
+			}
+			if len(eventsInDB) < chirpStreamReplayPage {
+				break
+			}
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(chirpStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.Done():
+			// Too slow; the client reconnects with Last-Event-ID.
+			return
+		case <-heartbeat.C:
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			if err != nil {
+				return
+			}
+		case ev := <-sub.Events():
+			if replayed[ev.Seq] || !matches(ev) || !slices.Contains(chirpStreamEventTypes, ev.Type) {
+				continue
+			}
+			err := writeChirpStreamEvent(w, ev)
+			if err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// publicChirpEventData is the part of a chirp event that clients get to
+// see. Deleted chirps are announced by id and author only.
+func publicChirpEventData(ev outbox.Event) (json.RawMessage, error) {
+	if ev.Type != eventChirpDeleted {
+		return ev.Payload, nil
+	}
+	var deleted struct {
+		ID     uuid.UUID `json:"id"`
+		UserID uuid.UUID `json:"user_id"`
+	}
+	err := json.Unmarshal(ev.Payload, &deleted)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(deleted)
+}
+
+// writeChirpStreamEvent writes ev in the event stream format.
+func writeChirpStreamEvent(w http.ResponseWriter, ev outbox.Event) error {
+	data, err := publicChirpEventData(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, data)
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/outbox"
+)
+
+func TestChirpFeedPublish(t *testing.T) {
+	now := time.Now().UTC()
+	published := now.Add(-time.Hour)
+	scheduled := now.Add(time.Hour)
+
+	chirpEvent := func(eventType string, publishedAt time.Time) outbox.Event {
+		payload, _ := json.Marshal(Chirp{ID: uuid.New(), PublishedAt: publishedAt})
+		return outbox.Event{ID: uuid.New(), Type: eventType, OccurredAt: now, Payload: payload}
+	}
+	deletedEvent := func(publishedAt time.Time) outbox.Event {
+		payload, _ := json.Marshal(map[string]any{"id": uuid.New(), "published_at": publishedAt})
+		return outbox.Event{ID: uuid.New(), Type: eventChirpDeleted, OccurredAt: now, Payload: payload}
+	}
+
+	tests := []struct {
+		name     string
+		ev       outbox.Event
+		wantType string
+	}{
+		{name: "created", ev: chirpEvent(eventChirpCreated, now), wantType: eventChirpCreated},
+		// Announced by chirp.published instead.
+		{name: "created scheduled", ev: chirpEvent(eventChirpCreated, scheduled)},
+		{name: "published", ev: chirpEvent(eventChirpPublished, now), wantType: eventChirpCreated},
+		{name: "updated", ev: chirpEvent(eventChirpUpdated, published), wantType: eventChirpUpdated},
+		{name: "updated scheduled", ev: chirpEvent(eventChirpUpdated, scheduled)},
+		{name: "deleted", ev: deletedEvent(published), wantType: eventChirpDeleted},
+		{name: "deleted before publication", ev: deletedEvent(scheduled)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newChirpFeed(nil)
+			sub := f.hub.Subscribe()
+			defer sub.Close()
+
+			f.publish(tt.ev)
+
+			select {
+			case ev := <-sub.Events():
+				if ev.Type != tt.wantType {
+					t.Errorf("published %q event, want %q", ev.Type, tt.wantType)
+				}
+			default:
+				if tt.wantType != "" {
+					t.Errorf("event wasn't published, want %q", tt.wantType)
+				}
+			}
+		})
+	}
+
+	// Whether an event is shown doesn't depend on when it is replayed.
+	t.Run("scheduled chirp replayed after its publication", func(t *testing.T) {
+		ev := chirpEvent(eventChirpCreated, now.Add(time.Minute))
+		ev.OccurredAt = now.Add(-time.Hour)
+		if _, ok := visibleChirpEvent(ev); ok {
+			t.Error("chirp.created of a scheduled chirp is visible")
+		}
+	})
+}
+
+func TestAnnounceChirp(t *testing.T) {
+	cfg := testConfig(t)
+	ctx := context.Background()
+
+	userDB, err := cfg.db.CreateUser(ctx, database.CreateUserParams{
+		Email:          "announce-" + uuid.NewString() + "@example.com",
+		HashedPassword: unusablePassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteUserOnCleanup(t, cfg, userDB)
+
+	publishAt := time.Now().UTC().Add(time.Hour)
+	chirpDB, err := cfg.db.CreateChirp(ctx, database.CreateChirpParams{
+		Body:      "later",
+		UserID:    userDB.ID,
+		PublishAt: sql.NullTime{Time: publishAt, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(chirpDBToChirpJSON(chirpDB))
+	created := outbox.Event{ID: uuid.New(), Type: eventChirpCreated, UserID: userDB.ID, OccurredAt: chirpDB.CreatedAt, Payload: payload}
+
+	var deferErr *outbox.DeferError
+	err = cfg.announceChirp(ctx, created)
+	if !errors.As(err, &deferErr) {
+		t.Fatalf("announceChirp() before publication = %v, want a deferral", err)
+	}
+
+	_, err = cfg.conn.Exec("UPDATE chirps SET published_at = NOW() - INTERVAL '1 second' WHERE id = $1", chirpDB.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The dispatcher may hand the event over more than once.
+	for range 2 {
+		err = cfg.announceChirp(ctx, created)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var n int
+	err = cfg.conn.QueryRow("SELECT COUNT(*) FROM domain_events WHERE type = $1 AND payload->>'id' = $2", eventChirpPublished, chirpDB.ID.String()).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("chirp announced %d times, want once", n)
+	}
+}
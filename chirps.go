@@ -1,67 +1,155 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/romusking/chirpy/internal/auth"
 	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/entitlements"
 )
 
 type Chirp struct {
-	ID        uuid.UUID `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	PublishedAt time.Time `json:"published_at"`
+	Body        string    `json:"body"`
+	UserID      uuid.UUID `json:"user_id"`
+	// ReplyToID is the chirp this one answers, if any.
+	ReplyToID *uuid.UUID `json:"reply_to_id"`
 }
 
-func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
+const (
+	maxChirpLength     = 140
+	maxLongChirpLength = 1000
 
-	token, err := auth.GetBearerToken(r.Header)
+	// Chirps a user can post in an hour.
+	chirpsPerHour       = 30
+	higherChirpsPerHour = 300
+)
 
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "no auth token in request", err)
-		return
+func maxChirpLengthFor(ent entitlements.Set) int {
+	if ent.Has(entitlements.FeatureLongChirps) {
+		return maxLongChirpLength
 	}
-	userID, err := auth.ValidateJWT(token, cfg.secret)
+	return maxChirpLength
+}
 
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid token in request", err)
-		return
-	}
+func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
 
 	type parameters struct {
-		Body string `json:"body"`
+		Body      string     `json:"body"`
+		PublishAt *time.Time `json:"publish_at"`
+		ReplyTo   *uuid.UUID `json:"reply_to"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Can't create chirp, invalid message.", err)
 		return
 	}
-	const maxChirpLength = 140
-	if len(params.Body) > maxChirpLength {
+
+	ent, err := cfg.entitlements.Entitlements(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check entitlements.", err)
+		return
+	}
+	if len(params.Body) > maxChirpLengthFor(ent) {
 		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
 		return
 	}
 	params.Body = filterProfane(params.Body)
 
-	chirpDB, err := cfg.db.CreateChirp(
+	var publishAt sql.NullTime
+	if params.PublishAt != nil {
+		if !ent.Has(entitlements.FeatureScheduledChirps) {
+			respondWithError(w, http.StatusForbidden, "Your plan doesn't include scheduled chirps.", nil)
+			return
+		}
+		if !params.PublishAt.After(time.Now()) {
+			respondWithError(w, http.StatusBadRequest, "publish_at must be in the future.", nil)
+			return
+		}
+		publishAt = sql.NullTime{Time: params.PublishAt.UTC(), Valid: true}
+	}
+
+	var replyTo uuid.NullUUID
+	if params.ReplyTo != nil {
+		parentDB, err := cfg.db.GetOneChirp(r.Context(), *params.ReplyTo)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp, database error.", err)
+			return
+		}
+		// Only chirps the caller can see can be answered.
+		if err != nil || (parentDB.PublishedAt.After(time.Now()) && parentDB.UserID != userID) {
+			respondWithError(w, http.StatusBadRequest, "Can't reply, chirp doesn't exist.", err)
+			return
+		}
+		replyTo = uuid.NullUUID{UUID: parentDB.ID, Valid: true}
+	}
+
+	quota := chirpsPerHour
+	if ent.Has(entitlements.FeatureHigherRateLimits) {
+		quota = higherChirpsPerHour
+	}
+	now := time.Now().UTC()
+	recent, err := cfg.db.CountChirpsSince(r.Context(), database.CountChirpsSinceParams{
+		UserID:    userID,
+		CreatedAt: now.Add(-time.Hour),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp, database error.", err)
+		return
+	}
+	if recent.Count >= int64(quota) {
+		respondWithRetryAfter(w, recent.Oldest.Add(time.Hour).Sub(now), "Too many chirps, try again later.")
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	chirpDB, err := qtx.CreateChirp(
 		r.Context(), database.CreateChirpParams{
-			Body:   params.Body,
-			UserID: userID})
+			Body:      params.Body,
+			UserID:    userID,
+			PublishAt: publishAt,
+			ReplyToID: replyTo})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp, database error.", err)
 		return
 	}
 	chirp := chirpDBToChirpJSON(chirpDB)
+
+	err = publishEventTx(r.Context(), qtx, eventChirpCreated, userID, chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp, database error.", err)
+		return
+	}
 	respondWithJSON(w, 201, chirp)
 
 }
@@ -86,6 +174,22 @@ func filterProfane(msg string) string {
 
 }
 
+// mentionedEmails returns the lowercased addresses mentioned in body as
+// @email, which is how users are referred to until they have handles.
+func mentionedEmails(body string) []string {
+	var emails []string
+	for _, word := range strings.Fields(body) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+		email := strings.ToLower(strings.TrimRight(word[1:], ".,;:!?)"))
+		if strings.Contains(email, "@") && !slices.Contains(emails, email) {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
 func (cfg *apiConfig) getAllChirps(w http.ResponseWriter, r *http.Request) {
 	author := r.URL.Query().Get("author_id")
 	sort := r.URL.Query().Get("sort")
@@ -124,11 +228,15 @@ func (cfg *apiConfig) getAllChirps(w http.ResponseWriter, r *http.Request) {
 
 func chirpDBToChirpJSON(chirpDB database.Chirp) Chirp {
 	chirp := Chirp{
-		ID:        chirpDB.ID,
-		CreatedAt: chirpDB.CreatedAt,
-		UpdatedAt: chirpDB.UpdatedAt,
-		Body:      chirpDB.Body,
-		UserID:    chirpDB.UserID,
+		ID:          chirpDB.ID,
+		CreatedAt:   chirpDB.CreatedAt,
+		UpdatedAt:   chirpDB.UpdatedAt,
+		PublishedAt: chirpDB.PublishedAt,
+		Body:        chirpDB.Body,
+		UserID:      chirpDB.UserID,
+	}
+	if chirpDB.ReplyToID.Valid {
+		chirp.ReplyToID = &chirpDB.ReplyToID.UUID
 	}
 	return chirp
 }
@@ -148,6 +256,13 @@ func (cfg *apiConfig) getOneChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Scheduled chirps are only visible to their author until published.
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if chirpInDB.PublishedAt.After(time.Now()) && principal.UserID != chirpInDB.UserID {
+		respondWithError(w, http.StatusNotFound, "Chirp doesn't exist.", nil)
+		return
+	}
+
 	chirp := chirpDBToChirpJSON(chirpInDB)
 
 	respondWithJSON(w, 200, chirp)
@@ -155,23 +270,114 @@ func (cfg *apiConfig) getOneChirp(w http.ResponseWriter, r *http.Request) {
 
 func (cfg *apiConfig) deleteAChirp(w http.ResponseWriter, r *http.Request) {
 
-	token, err := auth.GetBearerToken(r.Header)
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "no auth token in request", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't delete chirp, wrong UUID.", err)
 		return
 	}
-	userID, err := auth.ValidateJWT(token, cfg.secret)
 
+	chirpInDB, err := cfg.db.GetOneChirp(r.Context(), chirpID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid token in request", err)
+		respondWithError(w, http.StatusNotFound, "Chirp doesn't exist.", err)
 		return
 	}
 
+	// Moderators may take down anyone's chirps.
+	moderated := userID != chirpInDB.UserID
+	if moderated && !auth.RoleAtLeast(principal.Role, auth.RoleModerator) {
+		respondWithError(w, http.StatusForbidden, "Not yours, can't delete", err)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.DeleteAChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't delete chir.", err)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:  "chirp.deleted",
+		ActorID: uuid.NullUUID{UUID: userID, Valid: true},
+		Target:  chirpID.String(),
+		Metadata: map[string]string{
+			"author_id": chirpInDB.UserID.String(),
+			"moderated": strconv.FormatBool(moderated),
+		},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp, database error.", err)
+		return
+	}
+
+	err = publishEventTx(r.Context(), qtx, eventChirpDeleted, chirpInDB.UserID, map[string]any{
+		"id":           chirpID,
+		"user_id":      chirpInDB.UserID,
+		"published_at": chirpInDB.PublishedAt,
+		"moderated":    moderated,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// updateChirp changes the body of one of the caller's chirps, for users
+// whose plan includes editing.
+func (cfg *apiConfig) updateChirp(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
+
 	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
 
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't delete chirp, wrong UUID.", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't update chirp, wrong UUID.", err)
+		return
+	}
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't update chirp, invalid message.", err)
+		return
+	}
+
+	ent, err := cfg.entitlements.Entitlements(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check entitlements.", err)
+		return
+	}
+	if !ent.Has(entitlements.FeatureChirpEditing) {
+		respondWithError(w, http.StatusForbidden, "Your plan doesn't include editing chirps.", nil)
+		return
+	}
+	if len(params.Body) > maxChirpLengthFor(ent) {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long", nil)
 		return
 	}
 
@@ -180,17 +386,40 @@ func (cfg *apiConfig) deleteAChirp(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusNotFound, "Chirp doesn't exist.", err)
 		return
 	}
-
 	if userID != chirpInDB.UserID {
-		respondWithError(w, http.StatusForbidden, "Not yours, can't delete", err)
+		respondWithError(w, http.StatusForbidden, "Not yours, can't edit", nil)
 		return
 	}
 
-	err = cfg.db.DeleteAChirp(r.Context(), chirpID)
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't delete chir.", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp, database error.", err)
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
 
-	respondWithJSON(w, http.StatusNoContent, "")
+	chirpInDB, err = qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		ID:   chirpID,
+		Body: filterProfane(params.Body),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp, database error.", err)
+		return
+	}
+	chirp := chirpDBToChirpJSON(chirpInDB)
+
+	err = publishEventTx(r.Context(), qtx, eventChirpUpdated, userID, chirp)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update chirp, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirp)
 }
@@ -0,0 +1,7 @@
+package main
+
+import "database/sql"
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// testConfig returns an apiConfig connected to the database named by
+// CHIRPY_TEST_DB_URL, which must have the migrations in sql/schema
+// applied. Tests that need a database are skipped without it.
+func testConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dbURL := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbURL == "" {
+		t.Skip("CHIRPY_TEST_DB_URL not set")
+	}
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &apiConfig{
+		conn: db,
+		db:   database.New(db),
+	}
+}
+
+// deleteUserOnCleanup removes a user a test created, and everything that
+// cascades from it.
+func deleteUserOnCleanup(t *testing.T, cfg *apiConfig, userDB database.User) {
+	t.Cleanup(func() {
+		_, err := cfg.conn.Exec("DELETE FROM users WHERE id = $1", userDB.ID)
+		if err != nil {
+			t.Errorf("deleting test user: %s", err)
+		}
+	})
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+const maxDirectMessageLength = 1000
+
+type Conversation struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	UserID      uuid.UUID `json:"user_id"`
+	UnreadCount int64     `json:"unread_count"`
+}
+
+type DirectMessage struct {
+	ID             uuid.UUID  `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ConversationID uuid.UUID  `json:"conversation_id"`
+	SenderID       uuid.UUID  `json:"sender_id"`
+	Body           string     `json:"body"`
+	ReadAt         *time.Time `json:"read_at"`
+}
+
+// conversationDBToJSON shows the conversation from the side of userID:
+// UserID is the other participant.
+func conversationDBToJSON(conversationDB database.Conversation, userID uuid.UUID, unread int64) Conversation {
+	return Conversation{
+		ID:          conversationDB.ID,
+		CreatedAt:   conversationDB.CreatedAt,
+		UpdatedAt:   conversationDB.UpdatedAt,
+		UserID:      otherParticipant(conversationDB, userID),
+		UnreadCount: unread,
+	}
+}
+
+func otherParticipant(conversationDB database.Conversation, userID uuid.UUID) uuid.UUID {
+	if conversationDB.UserAID == userID {
+		return conversationDB.UserBID
+	}
+	return conversationDB.UserAID
+}
+
+func directMessageDBToJSON(messageDB database.DirectMessage) DirectMessage {
+	message := DirectMessage{
+		ID:             messageDB.ID,
+		CreatedAt:      messageDB.CreatedAt,
+		ConversationID: messageDB.ConversationID,
+		SenderID:       messageDB.SenderID,
+		Body:           messageDB.Body,
+	}
+	if messageDB.ReadAt.Valid {
+		message.ReadAt = &messageDB.ReadAt.Time
+	}
+	return message
+}
+
+// startConversation returns the caller's conversation with user_id,
+// creating it the first time.
+func (cfg *apiConfig) startConversation(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	type parameters struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't start conversation, user_id missing.", err)
+		return
+	}
+	if params.UserID == principal.UserID {
+		respondWithError(w, http.StatusBadRequest, "Can't start a conversation with yourself.", nil)
+		return
+	}
+
+	_, err = cfg.db.GetUserByID(r.Context(), params.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start conversation, database error.", err)
+		return
+	}
+
+	blocked, err := cfg.db.IsBlockedBetween(r.Context(), database.IsBlockedBetweenParams{
+		BlockerID: principal.UserID,
+		BlockedID: params.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start conversation, database error.", err)
+		return
+	}
+	if blocked {
+		respondWithError(w, http.StatusForbidden, "You can't message this user.", nil)
+		return
+	}
+
+	// Each pair has one conversation, stored with the lower id first.
+	userA, userB := principal.UserID, params.UserID
+	if bytes.Compare(userA[:], userB[:]) > 0 {
+		userA, userB = userB, userA
+	}
+	conversationDB, err := cfg.db.GetOrCreateConversation(r.Context(), database.GetOrCreateConversationParams{
+		UserAID: userA,
+		UserBID: userB,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start conversation, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, conversationDBToJSON(conversationDB, principal.UserID, 0))
+}
+
+// listConversations lists the caller's conversations, the one with the
+// latest message first.
+func (cfg *apiConfig) listConversations(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	conversationsInDB, err := cfg.db.ListConversations(r.Context(), database.ListConversationsParams{
+		UserID: principal.UserID,
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get conversations, database error.", err)
+		return
+	}
+
+	conversations := make([]Conversation, len(conversationsInDB))
+	for i, row := range conversationsInDB {
+		conversations[i] = conversationDBToJSON(database.Conversation{
+			ID:        row.ID,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+			UserAID:   row.UserAID,
+			UserBID:   row.UserBID,
+		}, principal.UserID, row.UnreadCount)
+	}
+	respondWithJSON(w, http.StatusOK, conversations)
+}
+
+// getConversationForRequest returns the conversation in the path if the
+// caller takes part in it, and has responded otherwise.
+func (cfg *apiConfig) getConversationForRequest(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (database.Conversation, bool) {
+	conversationID, err := uuid.Parse(r.PathValue("conversationID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't get conversation, wrong UUID.", err)
+		return database.Conversation{}, false
+	}
+
+	conversationDB, err := cfg.db.GetConversation(r.Context(), database.GetConversationParams{
+		ID:     conversationID,
+		UserID: userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "Conversation doesn't exist.", err)
+		return database.Conversation{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get conversation, database error.", err)
+		return database.Conversation{}, false
+	}
+	return conversationDB, true
+}
+
+// listDirectMessages lists the messages of a conversation, newest first.
+func (cfg *apiConfig) listDirectMessages(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	conversationDB, ok := cfg.getConversationForRequest(w, r, principal.UserID)
+	if !ok {
+		return
+	}
+
+	messagesInDB, err := cfg.db.ListDirectMessages(r.Context(), database.ListDirectMessagesParams{
+		ConversationID: conversationDB.ID,
+		Limit:          limit,
+		Offset:         offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get messages, database error.", err)
+		return
+	}
+
+	messages := make([]DirectMessage, len(messagesInDB))
+	for i, messageDB := range messagesInDB {
+		messages[i] = directMessageDBToJSON(messageDB)
+	}
+	respondWithJSON(w, http.StatusOK, messages)
+}
+
+// sendDirectMessage adds a message to a conversation, unless either
+// participant has blocked the other.
+func (cfg *apiConfig) sendDirectMessage(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't send message, invalid message.", err)
+		return
+	}
+	if params.Body == "" {
+		respondWithError(w, http.StatusBadRequest, "Message is empty", nil)
+		return
+	}
+	if len(params.Body) > maxDirectMessageLength {
+		respondWithError(w, http.StatusBadRequest, "Message is too long", nil)
+		return
+	}
+	params.Body = filterProfane(params.Body)
+
+	conversationDB, ok := cfg.getConversationForRequest(w, r, principal.UserID)
+	if !ok {
+		return
+	}
+
+	blocked, err := cfg.db.IsBlockedBetween(r.Context(), database.IsBlockedBetweenParams{
+		BlockerID: principal.UserID,
+		BlockedID: otherParticipant(conversationDB, principal.UserID),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send message, database error.", err)
+		return
+	}
+	if blocked {
+		respondWithError(w, http.StatusForbidden, "You can't message this user.", nil)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send message, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	messageDB, err := qtx.CreateDirectMessage(r.Context(), database.CreateDirectMessageParams{
+		ConversationID: conversationDB.ID,
+		SenderID:       principal.UserID,
+		Body:           params.Body,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send message, database error.", err)
+		return
+	}
+
+	err = qtx.TouchConversation(r.Context(), conversationDB.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send message, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send message, database error.", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, directMessageDBToJSON(messageDB))
+}
+
+// markConversationRead marks the messages the caller received in a
+// conversation as read.
+func (cfg *apiConfig) markConversationRead(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	conversationDB, ok := cfg.getConversationForRequest(w, r, principal.UserID)
+	if !ok {
+		return
+	}
+
+	_, err := cfg.db.MarkConversationRead(r.Context(), database.MarkConversationReadParams{
+		ConversationID: conversationDB.ID,
+		SenderID:       principal.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark conversation read, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+type UserBlock struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (cfg *apiConfig) listBlockedUsers(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	blocksInDB, err := cfg.db.ListBlockedUsers(r.Context(), database.ListBlockedUsersParams{
+		BlockerID: principal.UserID,
+		Limit:     limit,
+		Offset:    offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get blocked users, database error.", err)
+		return
+	}
+
+	blocks := make([]UserBlock, len(blocksInDB))
+	for i, blockDB := range blocksInDB {
+		blocks[i] = UserBlock{UserID: blockDB.BlockedID, CreatedAt: blockDB.CreatedAt}
+	}
+	respondWithJSON(w, http.StatusOK, blocks)
+}
+
+// blockUser stops user_id from messaging the caller, and the caller from
+// messaging them. Existing conversations can still be read.
+func (cfg *apiConfig) blockUser(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	type parameters struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't block user, user_id missing.", err)
+		return
+	}
+	if params.UserID == principal.UserID {
+		respondWithError(w, http.StatusBadRequest, "Can't block yourself.", nil)
+		return
+	}
+
+	_, err = cfg.db.GetUserByID(r.Context(), params.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't block user, database error.", err)
+		return
+	}
+
+	err = cfg.db.BlockUser(r.Context(), database.BlockUserParams{
+		BlockerID: principal.UserID,
+		BlockedID: params.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't block user, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) unblockUser(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't unblock user, wrong UUID.", err)
+		return
+	}
+
+	err = cfg.db.UnblockUser(r.Context(), database.UnblockUserParams{
+		BlockerID: principal.UserID,
+		BlockedID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unblock user, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
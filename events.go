@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// Domain events written to the outbox. Each is about one user, whose id
+// is stored with the event.
+const (
+	eventChirpCreated = "chirp.created"
+	eventChirpUpdated = "chirp.updated"
+	eventChirpDeleted = "chirp.deleted"
+	eventChirpLiked   = "chirp.liked"
+	// eventChirpPublished is written when a scheduled chirp is published.
+	// Chirps published straight away have only chirp.created.
+	eventChirpPublished = "chirp.published"
+	eventUserCreated    = "user.created"
+	eventUserUpgraded   = "user.upgraded"
+	eventUserFollowed   = "user.followed"
+)
+
+// publishEventTx writes an event to the outbox using the transaction that
+// makes the change, so that subscribers hear about the change if and only
+// if it is committed. data is stored as the event's JSON payload.
+func publishEventTx(ctx context.Context, qtx *database.Queries, eventType string, userID uuid.UUID, data any) error {
+	return publishEventIDTx(ctx, qtx, uuid.New(), eventType, userID, data)
+}
+
+// publishEventIDTx is publishEventTx for an event whose id is known, which
+// is only written once however often it is published.
+func publishEventIDTx(ctx context.Context, qtx *database.Queries, id uuid.UUID, eventType string, userID uuid.UUID, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return qtx.CreateDomainEvent(ctx, database.CreateDomainEventParams{
+		ID:      id,
+		Type:    eventType,
+		UserID:  userID,
+		Payload: payload,
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// followUser makes the caller follow a user. Users who have blocked each
+// other can't follow each other.
+func (cfg *apiConfig) followUser(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't follow user, wrong UUID.", err)
+		return
+	}
+	if userID == principal.UserID {
+		respondWithError(w, http.StatusBadRequest, "Can't follow yourself.", nil)
+		return
+	}
+
+	_, err = cfg.db.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user, database error.", err)
+		return
+	}
+
+	blocked, err := cfg.db.IsBlockedBetween(r.Context(), database.IsBlockedBetweenParams{
+		BlockerID: principal.UserID,
+		BlockedID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user, database error.", err)
+		return
+	}
+	if blocked {
+		respondWithError(w, http.StatusForbidden, "Can't follow this user.", nil)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	followed, err := qtx.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: principal.UserID,
+		FollowedID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user, database error.", err)
+		return
+	}
+
+	// Following someone again doesn't notify them again.
+	if followed > 0 {
+		err = publishEventTx(r.Context(), qtx, eventUserFollowed, principal.UserID, map[string]any{
+			"follower_id": principal.UserID,
+			"followed_id": userID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't follow user, database error.", err)
+			return
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't follow user, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) unfollowUser(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't unfollow user, wrong UUID.", err)
+		return
+	}
+
+	_, err = cfg.db.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: principal.UserID,
+		FollowedID: userID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
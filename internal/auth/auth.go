@@ -2,9 +2,11 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -29,54 +31,139 @@ func CheckPasswordHash(password, hash string) error {
 	return nil
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	token := jwt.NewWithClaims(
-		jwt.SigningMethodHS256,
-		jwt.RegisteredClaims{
-			Issuer:    "chirpy",
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+// challengeAudience marks the short-lived tokens handed out between the
+// password check and the second factor, so they can't pass as access tokens.
+const challengeAudience = "chirpy-2fa"
+
+// JWTConfig is everything needed to issue and check tokens.
+type JWTConfig struct {
+	Keys     *Keyring
+	Issuer   string
+	Audience string
+	// Leeway is the clock skew tolerated when checking exp, nbf and iat.
+	Leeway time.Duration
+}
+
+// Claims is what a valid access token says about its bearer.
+type Claims struct {
+	UserID  uuid.UUID
+	TokenID string
+	// SessionID is the login session the token was issued for, or
+	// uuid.Nil for tokens that don't belong to one.
+	SessionID uuid.UUID
+	// Role is the user's role when the token was issued.
+	Role      string
+	Scopes    []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+func (c Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+type tokenClaims struct {
+	// Scope is a space-separated list, as in RFC 8693.
+	Scope     string `json:"scope,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	Role      string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func MakeJWT(cfg JWTConfig, userID, sessionID uuid.UUID, role string, scopes []string, expiresIn time.Duration) (string, error) {
+	return makeJWT(cfg, cfg.Audience, userID, sessionID, role, scopes, expiresIn)
+}
+
+func ValidateJWT(tokenString string, cfg JWTConfig) (Claims, error) {
+	return validateJWT(cfg, cfg.Audience, tokenString)
+}
+
+// MakeChallengeJWT issues the short-lived token a user holds between
+// passing the password check and entering a second factor. It can't be
+// used as an access token.
+func MakeChallengeJWT(cfg JWTConfig, userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return makeJWT(cfg, challengeAudience, userID, uuid.Nil, "", nil, expiresIn)
+}
+
+func ValidateChallengeJWT(tokenString string, cfg JWTConfig) (uuid.UUID, error) {
+	claims, err := validateJWT(cfg, challengeAudience, tokenString)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return claims.UserID, nil
+}
+
+func makeJWT(cfg JWTConfig, audience string, userID, sessionID uuid.UUID, role string, scopes []string, expiresIn time.Duration) (string, error) {
+	now := time.Now()
+	claims := tokenClaims{
+		Scope: strings.Join(scopes, " "),
+		Role:  role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    cfg.Issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
 			Subject:   userID.String(),
-		})
-	return token.SignedString([]byte(tokenSecret))
+		},
+	}
+	if sessionID != uuid.Nil {
+		claims.SessionID = sessionID.String()
+	}
+	return cfg.Keys.sign(claims)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Verify signing method is what we expect
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		// Return the secret key as a []byte
-		return []byte(tokenSecret), nil
-	})
+func validateJWT(cfg JWTConfig, audience, tokenString string) (Claims, error) {
+	// The time based claims are checked below so that Leeway applies.
+	token, err := jwt.ParseWithClaims(tokenString, &tokenClaims{}, cfg.Keys.keyFunc, jwt.WithoutClaimsValidation())
+	if err != nil {
+		return Claims{}, fmt.Errorf("token invalid: %v", err)
+	}
+	claims, ok := token.Claims.(*tokenClaims)
+	if !ok {
+		return Claims{}, fmt.Errorf("token format invalid")
+	}
+
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-cfg.Leeway), true) {
+		return Claims{}, fmt.Errorf("token expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(cfg.Leeway), false) || !claims.VerifyIssuedAt(now.Add(cfg.Leeway), false) {
+		return Claims{}, fmt.Errorf("token used before issued")
+	}
+	if !claims.VerifyIssuer(cfg.Issuer, true) {
+		return Claims{}, fmt.Errorf("token issuer invalid: %v", claims.Issuer)
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return Claims{}, fmt.Errorf("token audience invalid: %v", claims.Audience)
+	}
+
+	id, err := uuid.Parse(claims.Subject)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("token invalid: %v", err)
+		return Claims{}, fmt.Errorf("token id invalid: %v", err)
+	}
+	result := Claims{
+		UserID:    id,
+		TokenID:   claims.ID,
+		Role:      claims.Role,
+		Scopes:    strings.Fields(claims.Scope),
+		ExpiresAt: claims.ExpiresAt.Time,
+	}
+	if claims.IssuedAt != nil {
+		result.IssuedAt = claims.IssuedAt.Time
 	}
-	var id uuid.UUID
-	if claims, ok := token.Claims.(*jwt.RegisteredClaims); ok {
-		// claims.Subject will give you the subject string
-		id, err = uuid.Parse(claims.Subject)
+	if claims.SessionID != "" {
+		result.SessionID, err = uuid.Parse(claims.SessionID)
 		if err != nil {
-			return uuid.Nil, fmt.Errorf("token id invalid: %v", err)
+			return Claims{}, fmt.Errorf("token session invalid: %v", err)
 		}
-		// Remember you'll need to convert this string back to a UUID
-	} else {
-		// handle error - claims weren't in the expected format
-		return uuid.Nil, fmt.Errorf("token format invalid: %v", err)
 	}
-	return id, nil
-
+	return result, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
-	token := headers.Get("Authorization")
-	if token == "" {
-		return "", fmt.Errorf("no auth token in the header")
-	}
-	token = strings.TrimPrefix(token, "Bearer")
-	token = strings.Trim(token, " 	")
-	return token, nil
+	return getCredentials(headers, "Bearer")
 }
 
 func MakeRefreshToken() (string, error) {
@@ -92,12 +179,30 @@ func MakeRefreshToken() (string, error) {
 	return token, nil
 }
 
-func GetAPIKey(headers http.Header) (string, error) {
-	token := headers.Get("Authorization")
-	if token == "" {
-		return "", fmt.Errorf("no auth token in the header")
+// PersonalTokenPrefix starts every personal access token, which tells them
+// apart from JWTs in an Authorization header.
+const PersonalTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		return "", err
 	}
-	token = strings.TrimPrefix(token, "ApiKey")
-	token = strings.Trim(token, " 	")
-	return token, nil
+	return PersonalTokenPrefix + token, nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+// HashToken digests high-entropy secrets such as refresh tokens, personal
+// access tokens and recovery codes, which don't need bcrypt's deliberate
+// slowness and have to be looked up directly.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func GetAPIKey(headers http.Header) (string, error) {
+	return getCredentials(headers, "ApiKey")
 }
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID      uuid.UUID
+	Scopes      []string
+	Role        string
+	IsChirpyRed bool
+	// SessionID, TokenID and ExpiresAt describe the credential that was
+	// presented, so that it can be revoked.
+	SessionID uuid.UUID
+	TokenID   string
+	ExpiresAt time.Time
+	// PersonalToken is set when the credential was a personal access
+	// token rather than a login session's access token.
+	PersonalToken bool
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the caller stored by the auth middleware.
+// ok is false for anonymous requests.
+func PrincipalFromContext(ctx context.Context) (p Principal, ok bool) {
+	p, ok = ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrNoAuthHeader        = errors.New("no auth token in the header")
+	ErrMalformedAuthHeader = errors.New("malformed authorization header")
+	ErrWrongAuthScheme     = errors.New("unexpected authorization scheme")
+)
+
+// ParseAuthorization splits an Authorization header value into its scheme
+// and credentials, following RFC 7235: the scheme is a token, separated
+// from the credentials by one or more spaces, and the credentials are a
+// single token68. Auth-param lists aren't used by any scheme we accept and
+// are rejected.
+func ParseAuthorization(value string) (scheme, credentials string, err error) {
+	scheme, rest, ok := strings.Cut(value, " ")
+	if scheme == "" || !isToken(scheme) {
+		return "", "", fmt.Errorf("%w: invalid scheme", ErrMalformedAuthHeader)
+	}
+	credentials = strings.TrimLeft(rest, " ")
+	if !ok || credentials == "" {
+		return "", "", fmt.Errorf("%w: missing credentials", ErrMalformedAuthHeader)
+	}
+	if !isToken68(credentials) {
+		return "", "", fmt.Errorf("%w: invalid credentials", ErrMalformedAuthHeader)
+	}
+	return scheme, credentials, nil
+}
+
+// getCredentials returns the credentials of the Authorization header if
+// it uses scheme, which is matched case-insensitively.
+func getCredentials(headers http.Header, scheme string) (string, error) {
+	value := headers.Get("Authorization")
+	if value == "" {
+		return "", ErrNoAuthHeader
+	}
+	got, credentials, err := ParseAuthorization(value)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(got, scheme) {
+		return "", fmt.Errorf("%w: got %s, want %s", ErrWrongAuthScheme, got, scheme)
+	}
+	return credentials, nil
+}
+
+// isToken reports whether s is an RFC 7230 token.
+func isToken(s string) bool {
+	for _, c := range []byte(s) {
+		if !isAlphaNum(c) && !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isToken68 reports whether s is an RFC 7235 token68: a run of
+// A-Z a-z 0-9 - . _ ~ + / optionally followed by = padding.
+func isToken68(s string) bool {
+	body := strings.TrimRight(s, "=")
+	if body == "" {
+		return false
+	}
+	for _, c := range []byte(body) {
+		if !isAlphaNum(c) && !strings.ContainsRune("-._~+/", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphaNum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestParseAuthorization(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		scheme      string
+		credentials string
+		wantErr     error
+	}{
+		{name: "bearer", value: "Bearer abc.def-ghi", scheme: "Bearer", credentials: "abc.def-ghi"},
+		{name: "lower case scheme", value: "bearer abc", scheme: "bearer", credentials: "abc"},
+		{name: "upper case scheme", value: "BEARER abc", scheme: "BEARER", credentials: "abc"},
+		{name: "several spaces", value: "Bearer    abc", scheme: "Bearer", credentials: "abc"},
+		{name: "token68 padding", value: "ApiKey YWJj==", scheme: "ApiKey", credentials: "YWJj=="},
+		{name: "token68 characters", value: "Bearer a-b.c_d~e+f/g", scheme: "Bearer", credentials: "a-b.c_d~e+f/g"},
+		{name: "empty", value: "", wantErr: ErrMalformedAuthHeader},
+		{name: "scheme only", value: "Bearer", wantErr: ErrMalformedAuthHeader},
+		{name: "empty credentials", value: "Bearer ", wantErr: ErrMalformedAuthHeader},
+		{name: "blank credentials", value: "Bearer    ", wantErr: ErrMalformedAuthHeader},
+		{name: "leading space", value: " Bearer abc", wantErr: ErrMalformedAuthHeader},
+		{name: "invalid scheme", value: "Bea(rer abc", wantErr: ErrMalformedAuthHeader},
+		{name: "padding only", value: "Bearer ==", wantErr: ErrMalformedAuthHeader},
+		{name: "auth-param list", value: `Digest realm="chirpy", nonce="abc"`, wantErr: ErrMalformedAuthHeader},
+		{name: "auth-param", value: "Bearer token=abc", wantErr: ErrMalformedAuthHeader},
+		{name: "space in credentials", value: "Bearer abc def", wantErr: ErrMalformedAuthHeader},
+		{name: "trailing space", value: "Bearer abc ", wantErr: ErrMalformedAuthHeader},
+		{name: "tab separator", value: "Bearer\tabc", wantErr: ErrMalformedAuthHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme, credentials, err := ParseAuthorization(tt.value)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseAuthorization(%q) error = %v, want %v", tt.value, err, tt.wantErr)
+			}
+			if scheme != tt.scheme || credentials != tt.credentials {
+				t.Errorf("ParseAuthorization(%q) = %q, %q, want %q, %q", tt.value, scheme, credentials, tt.scheme, tt.credentials)
+			}
+		})
+	}
+}
+
+func TestGetBearerToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		token   string
+		wantErr error
+	}{
+		{name: "bearer", value: "Bearer abc", token: "abc"},
+		{name: "case-insensitive scheme", value: "bEaReR abc", token: "abc"},
+		{name: "no header", value: "", wantErr: ErrNoAuthHeader},
+		{name: "wrong scheme", value: "ApiKey abc", wantErr: ErrWrongAuthScheme},
+		{name: "basic", value: "Basic YWxhZGRpbjpvcGVuc2VzYW1l", wantErr: ErrWrongAuthScheme},
+		{name: "malformed", value: "Bearer", wantErr: ErrMalformedAuthHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			if tt.value != "" {
+				headers.Set("Authorization", tt.value)
+			}
+			token, err := GetBearerToken(headers)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetBearerToken(%q) error = %v, want %v", tt.value, err, tt.wantErr)
+			}
+			if token != tt.token {
+				t.Errorf("GetBearerToken(%q) = %q, want %q", tt.value, token, tt.token)
+			}
+		})
+	}
+}
+
+func TestGetAPIKey(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("Authorization", "apikey abc123")
+	key, err := GetAPIKey(headers)
+	if err != nil || key != "abc123" {
+		t.Errorf("GetAPIKey() = %q, %v, want %q, nil", key, err, "abc123")
+	}
+
+	headers.Set("Authorization", "Bearer abc123")
+	_, err = GetAPIKey(headers)
+	if !errors.Is(err, ErrWrongAuthScheme) {
+		t.Errorf("GetAPIKey() with a bearer token error = %v, want %v", err, ErrWrongAuthScheme)
+	}
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Key is a single JWT key, identified in token headers by its kid.
+// Verification-only keys have no private half.
+type Key struct {
+	ID      string
+	method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+func NewHMACKey(kid string, secret []byte) Key {
+	return Key{
+		ID:      kid,
+		method:  jwt.SigningMethodHS256,
+		private: secret,
+		public:  secret,
+	}
+}
+
+// ParsePrivateKeyPEM reads an RSA (RS256) or Ed25519 (EdDSA) private key.
+func ParsePrivateKeyPEM(kid string, data []byte) (Key, error) {
+	if rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return Key{ID: kid, method: jwt.SigningMethodRS256, private: rsaKey, public: &rsaKey.PublicKey}, nil
+	}
+	edKey, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return Key{}, fmt.Errorf("key %s is neither an RSA nor an Ed25519 private key", kid)
+	}
+	priv, ok := edKey.(ed25519.PrivateKey)
+	if !ok {
+		return Key{}, fmt.Errorf("key %s is neither an RSA nor an Ed25519 private key", kid)
+	}
+	return Key{ID: kid, method: jwt.SigningMethodEdDSA, private: priv, public: priv.Public()}, nil
+}
+
+// ParsePublicKeyPEM reads an RSA or Ed25519 public key that is still
+// accepted for verification, typically the previous signing key during a
+// rotation.
+func ParsePublicKeyPEM(kid string, data []byte) (Key, error) {
+	if rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return Key{ID: kid, method: jwt.SigningMethodRS256, public: rsaKey}, nil
+	}
+	edKey, err := jwt.ParseEdPublicKeyFromPEM(data)
+	if err != nil {
+		return Key{}, fmt.Errorf("key %s is neither an RSA nor an Ed25519 public key", kid)
+	}
+	return Key{ID: kid, method: jwt.SigningMethodEdDSA, public: edKey}, nil
+}
+
+// Keyring signs tokens with one key and verifies them with any key it
+// holds, so that old tokens stay valid while a new key is rolled out.
+type Keyring struct {
+	signing Key
+	keys    map[string]Key
+	// legacyKID is used for tokens minted before kid headers existed.
+	legacyKID string
+}
+
+func NewKeyring(signing Key, verifyOnly ...Key) (*Keyring, error) {
+	if signing.private == nil {
+		return nil, errors.New("signing key has no private key")
+	}
+	k := &Keyring{
+		signing: signing,
+		keys:    map[string]Key{signing.ID: signing},
+	}
+	for _, key := range verifyOnly {
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %s", key.ID)
+		}
+		k.keys[key.ID] = key
+	}
+	return k, nil
+}
+
+// SetLegacyKey names the key that verifies tokens without a kid header.
+func (k *Keyring) SetLegacyKey(kid string) error {
+	if _, ok := k.keys[kid]; !ok {
+		return fmt.Errorf("unknown key id %s", kid)
+	}
+	k.legacyKID = kid
+	return nil
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.method, claims)
+	token.Header["kid"] = k.signing.ID
+	return token.SignedString(k.signing.private)
+}
+
+func (k *Keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = k.legacyKID
+	}
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %v", token.Header["kid"])
+	}
+	// Verify signing method is what the key was made for
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// JWK is the public half of a key in RFC 7517 form.
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public keys other services need to verify our tokens.
+// Symmetric keys are never published.
+func (k *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, key := range k.keys {
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType: "RSA",
+				KeyID:   key.ID,
+				Use:     "sig",
+				Alg:     key.method.Alg(),
+				N:       base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				KeyType: "OKP",
+				KeyID:   key.ID,
+				Use:     "sig",
+				Alg:     key.method.Alg(),
+				Curve:   "Ed25519",
+				X:       base64.RawURLEncoding.EncodeToString(pub),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+	return set
+}
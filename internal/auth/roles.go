@@ -0,0 +1,32 @@
+package auth
+
+import "slices"
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// roleRanks orders the roles so that each one includes the ones before it.
+var roleRanks = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func ValidRole(role string) bool {
+	return slices.Contains(roleRanks, role)
+}
+
+// RoleAtLeast reports whether role grants everything min does. Unknown
+// roles grant nothing.
+func RoleAtLeast(role, min string) bool {
+	have := slices.Index(roleRanks, role)
+	return have >= 0 && have >= slices.Index(roleRanks, min)
+}
+
+// ScopesForRole returns the scopes a login session gets for a user with
+// role.
+func ScopesForRole(role string) []string {
+	if role == RoleAdmin {
+		return append(slices.Clone(SessionScopes), ScopeAdmin)
+	}
+	return SessionScopes
+}
@@ -0,0 +1,14 @@
+package auth
+
+const (
+	// ScopeChirpsWrite allows posting and deleting chirps.
+	ScopeChirpsWrite = "chirps:write"
+	// ScopeAccount allows managing the user's own account: profile,
+	// sessions and second factor.
+	ScopeAccount = "account"
+	// ScopeAdmin allows the /admin endpoints.
+	ScopeAdmin = "admin"
+)
+
+// SessionScopes are granted to tokens obtained by logging in.
+var SessionScopes = []string{ScopeChirpsWrite, ScopeAccount}
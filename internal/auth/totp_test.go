@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B gives 8 digits; chirpy keeps the last 6.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	key := []byte("12345678901234567890")
+	for _, tt := range tests {
+		got := totpCode(key, uint64(tt.unix/totpPeriod))
+		if got != tt.want {
+			t.Errorf("totpCode() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		code     string
+		secret   string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", code: "050471", secret: rfc6238Secret, wantStep: counter, wantOK: true},
+		{name: "previous step", code: "081804", secret: rfc6238Secret, wantStep: counter - 1, wantOK: true},
+		{name: "next step", code: totpCode([]byte("12345678901234567890"), uint64(counter+1)), secret: rfc6238Secret, wantStep: counter + 1, wantOK: true},
+		{name: "two steps ago", code: totpCode([]byte("12345678901234567890"), uint64(counter-2)), secret: rfc6238Secret},
+		{name: "two steps ahead", code: totpCode([]byte("12345678901234567890"), uint64(counter+2)), secret: rfc6238Secret},
+		{name: "surrounding space", code: " 050471\n", secret: rfc6238Secret, wantStep: counter, wantOK: true},
+		{name: "lower case secret", code: "050471", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", wantStep: counter, wantOK: true},
+		{name: "wrong code", code: "123456", secret: rfc6238Secret},
+		{name: "8 digits", code: "14050471", secret: rfc6238Secret},
+		{name: "bad secret", code: "050471", secret: "not base32!"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.code, tt.secret, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("ValidateTOTP(%q) = %d, %v, want %d, %v", tt.code, step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
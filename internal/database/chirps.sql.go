@@ -7,29 +7,63 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const countChirpsSince = `-- name: CountChirpsSince :one
+SELECT COUNT(*) AS count, COALESCE(MIN(created_at), NOW())::timestamp AS oldest
+FROM chirps
+WHERE user_id = $1 AND created_at > $2
+`
+
+type CountChirpsSinceParams struct {
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type CountChirpsSinceRow struct {
+	Count  int64
+	Oldest time.Time
+}
+
+func (q *Queries) CountChirpsSince(ctx context.Context, arg CountChirpsSinceParams) (CountChirpsSinceRow, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsSince, arg.UserID, arg.CreatedAt)
+	var i CountChirpsSinceRow
+	err := row.Scan(&i.Count, &i.Oldest)
+	return i, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, published_at, reply_to_id)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    COALESCE($3, NOW()),
+    $4
 )
-RETURNING id, created_at, updated_at, body, user_id
+RETURNING id, created_at, updated_at, body, user_id, published_at, reply_to_id
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body      string
+	UserID    uuid.UUID
+	PublishAt sql.NullTime
+	ReplyToID uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.PublishAt,
+		arg.ReplyToID,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,6 +71,8 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.PublishedAt,
+		&i.ReplyToID,
 	)
 	return i, err
 }
@@ -52,8 +88,9 @@ func (q *Queries) DeleteAChirp(ctx context.Context, id uuid.UUID) error {
 }
 
 const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-ORDER BY created_at ASC
+SELECT id, created_at, updated_at, body, user_id, published_at, reply_to_id FROM chirps
+WHERE published_at <= NOW()
+ORDER BY published_at ASC
 `
 
 func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
@@ -71,6 +108,8 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.PublishedAt,
+			&i.ReplyToID,
 		); err != nil {
 			return nil, err
 		}
@@ -86,9 +125,9 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getAllChirpsByAuthor = `-- name: GetAllChirpsByAuthor :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC
+SELECT id, created_at, updated_at, body, user_id, published_at, reply_to_id FROM chirps
+WHERE user_id = $1 AND published_at <= NOW()
+ORDER BY published_at ASC
 `
 
 func (q *Queries) GetAllChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
@@ -106,6 +145,8 @@ func (q *Queries) GetAllChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.PublishedAt,
+			&i.ReplyToID,
 		); err != nil {
 			return nil, err
 		}
@@ -121,7 +162,7 @@ func (q *Queries) GetAllChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([
 }
 
 const getOneChirp = `-- name: GetOneChirp :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, published_at, reply_to_id FROM chirps
 WHERE id = $1
 `
 
@@ -134,6 +175,34 @@ func (q *Queries) GetOneChirp(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.PublishedAt,
+		&i.ReplyToID,
+	)
+	return i, err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps SET (body, updated_at) = ($2, NOW())
+WHERE id = $1
+RETURNING id, created_at, updated_at, body, user_id, published_at, reply_to_id
+`
+
+type UpdateChirpBodyParams struct {
+	ID   uuid.UUID
+	Body string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.PublishedAt,
+		&i.ReplyToID,
 	)
 	return i, err
 }
@@ -6,26 +6,171 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type AuditEvent struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	ActorID   uuid.NullUUID
+	Action    string
+	Target    sql.NullString
+	IpAddress string
+	UserAgent string
+	Metadata  json.RawMessage
+}
+
 type Chirp struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Body        string
+	UserID      uuid.UUID
+	PublishedAt time.Time
+	ReplyToID   uuid.NullUUID
+}
+
+type ChirpLike struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type Conversation struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserAID   uuid.UUID
+	UserBID   uuid.UUID
+}
+
+type DirectMessage struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	ConversationID uuid.UUID
+	SenderID       uuid.UUID
+	Body           string
+	ReadAt         sql.NullTime
+}
+
+type DomainEvent struct {
+	ID            uuid.UUID
+	Seq           int64
+	OccurredAt    time.Time
+	Type          string
+	UserID        uuid.UUID
+	Payload       json.RawMessage
+	DispatchedAt  sql.NullTime
+	Attempts      int32
+	NextAttemptAt time.Time
+	LastError     sql.NullString
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FollowedID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type LoginAttempt struct {
+	Key          string
+	Failures     int32
+	LastFailedAt time.Time
+}
+
+type Notification struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Body      string
+	UserID    uuid.UUID
+	Type      string
+	GroupKey  string
+	ActorIds  []uuid.UUID
+	SubjectID uuid.NullUUID
+	ReadAt    sql.NullTime
+}
+
+type NotificationMute struct {
+	UserID    uuid.UUID
+	Type      string
+	CreatedAt time.Time
+}
+
+type OidcLoginState struct {
+	State        string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+	ExpiresAt  sql.NullTime
+	RevokedAt  sql.NullTime
+	Name       string
+	Scopes     []string
+	TokenHash  string
+	UserID     uuid.UUID
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
+	CodeHash  string
 	UserID    uuid.UUID
 }
 
 type RefreshToken struct {
-	Token     string
+	TokenHash string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	ExpiresAt time.Time
 	RevokedAt sql.NullTime
 	UserID    uuid.UUID
+	SessionID uuid.UUID
+}
+
+type RevokedToken struct {
+	ID        string
+	ExpiresAt time.Time
+}
+
+type Session struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	RevokedAt  sql.NullTime
+	UserAgent  string
+	IpAddress  string
+	UserID     uuid.UUID
+}
+
+type Subscription struct {
+	ID                 uuid.UUID
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	UserID             uuid.UUID
+	Plan               string
+	Status             string
+	Source             string
+	CurrentPeriodStart time.Time
+	CurrentPeriodEnd   sql.NullTime
+	CanceledAt         sql.NullTime
+	LastEventAt        sql.NullTime
+}
+
+type TotpStep struct {
+	UserID   uuid.UUID
+	LastStep int64
+	UsedAt   time.Time
 }
 
 type User struct {
@@ -34,5 +179,69 @@ type User struct {
 	UpdatedAt      time.Time
 	Email          string
 	HashedPassword string
-	IsChirpyRed    sql.NullBool
+	TotpSecret     sql.NullString
+	TotpEnabled    bool
+	Role           string
+	SuspendedAt    sql.NullTime
+}
+
+type UserBlock struct {
+	BlockerID uuid.UUID
+	BlockedID uuid.UUID
+	CreatedAt time.Time
+}
+
+type UserIdentity struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Provider  string
+	Subject   string
+	Email     string
+	UserID    uuid.UUID
+}
+
+type UserTokenCutoff struct {
+	UserID    uuid.UUID
+	NotBefore time.Time
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	SubscriptionID uuid.UUID
+	EventType      string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastAttemptAt  sql.NullTime
+	ResponseStatus sql.NullInt32
+	LastError      sql.NullString
+	EventID        uuid.NullUUID
+}
+
+type WebhookEvent struct {
+	ID          uuid.UUID
+	ReceivedAt  time.Time
+	UpdatedAt   time.Time
+	ProcessedAt sql.NullTime
+	Source      string
+	EventID     string
+	EventType   string
+	Payload     json.RawMessage
+	Status      string
+	Attempts    int32
+	LastError   sql.NullString
+}
+
+type WebhookSubscription struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	Url        string
+	EventTypes []string
+	Secret     string
+	AllUsers   bool
 }
@@ -14,66 +14,99 @@ import (
 
 const createRefToken = `-- name: CreateRefToken :one
 INSERT INTO refresh_tokens (
-    token ,
+    token_hash ,
     created_at ,
     updated_at ,   
     expires_at ,  
-    user_id )
+    user_id ,
+    session_id )
 VALUES (
     $1,
     NOW(),
     NOW(),
     $3,
-    $2
+    $2,
+    $4
 )
-RETURNING token, created_at, updated_at, expires_at, revoked_at, user_id
+RETURNING token_hash, created_at, updated_at, expires_at, revoked_at, user_id, session_id
 `
 
 type CreateRefTokenParams struct {
-	Token     string
+	TokenHash string
 	UserID    uuid.UUID
 	ExpiresAt time.Time
+	SessionID uuid.UUID
 }
 
 func (q *Queries) CreateRefToken(ctx context.Context, arg CreateRefTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.SessionID,
+	)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
 		&i.UserID,
+		&i.SessionID,
 	)
 	return i, err
 }
 
 const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT token, created_at, updated_at, expires_at, revoked_at, user_id FROM refresh_tokens
-WHERE token = $1
+SELECT token_hash, created_at, updated_at, expires_at, revoked_at, user_id, session_id FROM refresh_tokens
+WHERE token_hash = $1
 `
 
-func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, token)
+func (q *Queries) GetUserFromRefreshToken(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.ExpiresAt,
 		&i.RevokedAt,
 		&i.UserID,
+		&i.SessionID,
 	)
 	return i, err
 }
 
-const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
+const revokeRefreshToken = `-- name: RevokeRefreshToken :execrows
+UPDATE refresh_tokens SET (updated_at, revoked_at) = (NOW(), NOW())
+WHERE token_hash = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshToken(ctx context.Context, tokenHash string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeRefreshToken, tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeSessionRefreshTokens = `-- name: RevokeSessionRefreshTokens :exec
+UPDATE refresh_tokens SET (updated_at, revoked_at) = (NOW(), NOW())
+WHERE session_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeSessionRefreshTokens(ctx context.Context, sessionID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeSessionRefreshTokens, sessionID)
+	return err
+}
+
+const revokeUserRefreshTokens = `-- name: RevokeUserRefreshTokens :exec
 UPDATE refresh_tokens SET (updated_at, revoked_at) = (NOW(), NOW())
-WHERE token = $1
+WHERE user_id = $1 AND revoked_at IS NULL
 `
 
-func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, revokeRefreshToken, token)
+func (q *Queries) RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeUserRefreshTokens, userID)
 	return err
 }
@@ -7,10 +7,25 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const bootstrapAdmin = `-- name: BootstrapAdmin :execrows
+UPDATE users SET (role, updated_at) = ('admin', NOW())
+WHERE id = $1
+AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin')
+`
+
+func (q *Queries) BootstrapAdmin(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, bootstrapAdmin, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
@@ -20,7 +35,7 @@ VALUES (
     $1,
     $2
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled, role, suspended_at
 `
 
 type CreateUserParams struct {
@@ -37,7 +52,10 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.Role,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
@@ -51,8 +69,64 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 	return err
 }
 
+const enableTOTP = `-- name: EnableTOTP :exec
+UPDATE users SET (totp_enabled, updated_at) = (true, NOW())
+WHERE id = $1
+`
+
+func (q *Queries) EnableTOTP(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, enableTOTP, id)
+	return err
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled, role, suspended_at FROM users
+WHERE lower(email) = lower($1)
+ORDER BY created_at
+LIMIT 1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, lower string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, lower)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.Role,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled, role, suspended_at FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.Role,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
 const getUserPassword = `-- name: GetUserPassword :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled, role, suspended_at FROM users
 WHERE email = $1
 `
 
@@ -65,19 +139,155 @@ func (q *Queries) GetUserPassword(ctx context.Context, email string) (User, erro
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.Role,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const listUsers = `-- name: ListUsers :many
+SELECT users.id, users.created_at, users.updated_at, users.email, users.hashed_password, users.totp_secret, users.totp_enabled, users.role, users.suspended_at, EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE subscriptions.user_id = users.id AND plan = 'chirpy_red' AND status = 'active'
+    AND (current_period_end IS NULL OR current_period_end > NOW())
+) AS is_chirpy_red
+FROM users
+WHERE $1::text = ''
+OR email ILIKE '%' || replace(replace(replace($1::text, '\', '\\'), '%', '\%'), '_', '\_') || '%' ESCAPE '\'
+ORDER BY created_at
+LIMIT $2 OFFSET $3
+`
+
+type ListUsersParams struct {
+	Search string
+	Limit  int32
+	Offset int32
+}
+
+type ListUsersRow struct {
+	User        User
+	IsChirpyRed bool
+}
+
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUsers, arg.Search, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUsersRow
+	for rows.Next() {
+		var i ListUsersRow
+		if err := rows.Scan(
+			&i.User.ID,
+			&i.User.CreatedAt,
+			&i.User.UpdatedAt,
+			&i.User.Email,
+			&i.User.HashedPassword,
+			&i.User.TotpSecret,
+			&i.User.TotpEnabled,
+			&i.User.Role,
+			&i.User.SuspendedAt,
+			&i.IsChirpyRed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockAdminBootstrap = `-- name: LockAdminBootstrap :exec
+SELECT pg_advisory_xact_lock(hashtext('chirpy.bootstrap_admin'))
+`
+
+func (q *Queries) LockAdminBootstrap(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, lockAdminBootstrap)
+	return err
+}
+
+const setTOTPSecret = `-- name: SetTOTPSecret :exec
+UPDATE users SET (totp_secret, totp_enabled, updated_at) = ($2, false, NOW())
+WHERE id = $1
+`
+
+type SetTOTPSecretParams struct {
+	ID         uuid.UUID
+	TotpSecret sql.NullString
+}
+
+func (q *Queries) SetTOTPSecret(ctx context.Context, arg SetTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, setTOTPSecret, arg.ID, arg.TotpSecret)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users SET (role, updated_at) = ($2, NOW())
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled, role, suspended_at
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.Role,
+		&i.SuspendedAt,
+	)
+	return i, err
+}
+
+const suspendUser = `-- name: SuspendUser :one
+UPDATE users SET (suspended_at, updated_at) = (NOW(), NOW())
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled, role, suspended_at
+`
+
+func (q *Queries) SuspendUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, suspendUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.Role,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
 
-const makeUserRed = `-- name: MakeUserRed :one
-UPDATE users SET (is_chirpy_red, updated_at) = (true, NOW())
+const unsuspendUser = `-- name: UnsuspendUser :one
+UPDATE users SET (suspended_at, updated_at) = (NULL, NOW())
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled, role, suspended_at
 `
 
-func (q *Queries) MakeUserRed(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRowContext(ctx, makeUserRed, id)
+func (q *Queries) UnsuspendUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, unsuspendUser, id)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -85,7 +295,10 @@ func (q *Queries) MakeUserRed(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.Role,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
@@ -93,7 +306,7 @@ func (q *Queries) MakeUserRed(ctx context.Context, id uuid.UUID) (User, error) {
 const updateUserDetails = `-- name: UpdateUserDetails :one
 UPDATE users SET (email, hashed_password, updated_at) = ($1, $2, NOW())
 WHERE id = $3
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, totp_secret, totp_enabled, role, suspended_at
 `
 
 type UpdateUserDetailsParams struct {
@@ -111,7 +324,10 @@ func (q *Queries) UpdateUserDetails(ctx context.Context, arg UpdateUserDetailsPa
 		&i.UpdatedAt,
 		&i.Email,
 		&i.HashedPassword,
-		&i.IsChirpyRed,
+		&i.TotpSecret,
+		&i.TotpEnabled,
+		&i.Role,
+		&i.SuspendedAt,
 	)
 	return i, err
 }
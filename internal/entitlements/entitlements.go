@@ -0,0 +1,112 @@
+// Package entitlements decides which paid features a user may use. Which
+// plan includes which feature is configuration, so handlers only ever ask
+// about features.
+package entitlements
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type Feature string
+
+const (
+	// FeatureLongChirps raises the chirp length limit.
+	FeatureLongChirps Feature = "long_chirps"
+	// FeatureChirpEditing allows changing a chirp after posting it.
+	FeatureChirpEditing Feature = "chirp_editing"
+	// FeatureScheduledChirps allows posting chirps that appear later.
+	FeatureScheduledChirps Feature = "scheduled_chirps"
+	// FeatureHigherRateLimits raises how many chirps can be posted an hour.
+	FeatureHigherRateLimits Feature = "higher_rate_limits"
+)
+
+var features = []Feature{
+	FeatureLongChirps,
+	FeatureChirpEditing,
+	FeatureScheduledChirps,
+	FeatureHigherRateLimits,
+}
+
+// FreePlan is the plan every user is on, with or without a subscription.
+const FreePlan = "free"
+
+// Config maps a plan to the features it includes.
+type Config map[string][]Feature
+
+// DefaultConfig puts every feature in Chirpy Red.
+var DefaultConfig = Config{
+	"chirpy_red": features,
+}
+
+// ParseConfig reads a Config written as plan=feature,feature;plan=feature,
+// for example "chirpy_red=long_chirps,chirp_editing;free=long_chirps".
+func ParseConfig(s string) (Config, error) {
+	cfg := Config{}
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		plan, list, ok := strings.Cut(entry, "=")
+		plan = strings.TrimSpace(plan)
+		if !ok || plan == "" {
+			return nil, fmt.Errorf("entitlements: invalid entry %q", entry)
+		}
+		cfg[plan] = []Feature{}
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !slices.Contains(features, Feature(name)) {
+				return nil, fmt.Errorf("entitlements: unknown feature %q for plan %q", name, plan)
+			}
+			cfg[plan] = append(cfg[plan], Feature(name))
+		}
+	}
+	return cfg, nil
+}
+
+// PlanSource looks up the plans a user currently pays for.
+type PlanSource interface {
+	ActivePlans(ctx context.Context, userID uuid.UUID) ([]string, error)
+}
+
+type Service struct {
+	cfg    Config
+	source PlanSource
+}
+
+func New(cfg Config, source PlanSource) *Service {
+	return &Service{cfg: cfg, source: source}
+}
+
+// Set is what one user is entitled to.
+type Set struct {
+	features map[Feature]bool
+}
+
+func (s Set) Has(f Feature) bool {
+	return s.features[f]
+}
+
+// Entitlements collects the features of every active plan of userID,
+// including the free plan.
+func (s *Service) Entitlements(ctx context.Context, userID uuid.UUID) (Set, error) {
+	plans, err := s.source.ActivePlans(ctx, userID)
+	if err != nil {
+		return Set{}, err
+	}
+	set := Set{features: map[Feature]bool{}}
+	for _, plan := range append(plans, FreePlan) {
+		for _, f := range s.cfg[plan] {
+			set.features[f] = true
+		}
+	}
+	return set, nil
+}
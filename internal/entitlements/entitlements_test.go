@@ -0,0 +1,103 @@
+package entitlements
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Config
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: Config{}},
+		{
+			name:  "several plans",
+			value: " chirpy_red = long_chirps, chirp_editing ; pro=scheduled_chirps",
+			want: Config{
+				"chirpy_red": {FeatureLongChirps, FeatureChirpEditing},
+				"pro":        {FeatureScheduledChirps},
+			},
+		},
+		{name: "free plan", value: "free=long_chirps", want: Config{FreePlan: {FeatureLongChirps}}},
+		{name: "empty feature list", value: "chirpy_red=", want: Config{"chirpy_red": {}}},
+		{name: "trailing separators", value: "chirpy_red=long_chirps,;", want: Config{"chirpy_red": {FeatureLongChirps}}},
+		{name: "unknown feature", value: "chirpy_red=long_chirps,teleportation", wantErr: true},
+		{name: "no equals sign", value: "chirpy_red", wantErr: true},
+		{name: "no plan", value: "=long_chirps", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseConfig(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseConfig(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseConfig(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeSource struct {
+	plans []string
+	err   error
+}
+
+func (s fakeSource) ActivePlans(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	return s.plans, s.err
+}
+
+func TestEntitlements(t *testing.T) {
+	cfg := Config{
+		FreePlan:     {FeatureLongChirps},
+		"chirpy_red": {FeatureChirpEditing},
+		"pro":        {FeatureChirpEditing, FeatureScheduledChirps},
+	}
+
+	tests := []struct {
+		name  string
+		plans []string
+		want  []Feature
+	}{
+		{name: "free only", want: []Feature{FeatureLongChirps}},
+		{name: "one plan", plans: []string{"chirpy_red"}, want: []Feature{FeatureLongChirps, FeatureChirpEditing}},
+		{
+			name:  "union of plans",
+			plans: []string{"chirpy_red", "pro"},
+			want:  []Feature{FeatureLongChirps, FeatureChirpEditing, FeatureScheduledChirps},
+		},
+		{name: "unconfigured plan", plans: []string{"legacy"}, want: []Feature{FeatureLongChirps}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set, err := New(cfg, fakeSource{plans: tt.plans}).Entitlements(context.Background(), uuid.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range features {
+				want := slices.Contains(tt.want, f)
+				if set.Has(f) != want {
+					t.Errorf("Has(%s) = %v, want %v", f, set.Has(f), want)
+				}
+			}
+		})
+	}
+
+	t.Run("source error", func(t *testing.T) {
+		errSource := errors.New("database down")
+		_, err := New(cfg, fakeSource{err: errSource}).Entitlements(context.Background(), uuid.New())
+		if !errors.Is(err, errSource) {
+			t.Errorf("Entitlements() error = %v, want %v", err, errSource)
+		}
+	})
+}
@@ -0,0 +1,21 @@
+package entitlements
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// PostgresSource reads active plans from the subscriptions table.
+type PostgresSource struct {
+	db *database.Queries
+}
+
+func NewPostgresSource(db *database.Queries) *PostgresSource {
+	return &PostgresSource{db: db}
+}
+
+func (s *PostgresSource) ActivePlans(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	return s.db.ListActivePlans(ctx, userID)
+}
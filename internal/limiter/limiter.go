@@ -0,0 +1,152 @@
+// Package limiter tracks failed login attempts per key (an email address or
+// a client IP) and tells callers how long a key has to back off.
+package limiter
+
+import (
+	"context"
+	"time"
+)
+
+// Attempts is the failure history stored for a single key.
+type Attempts struct {
+	Failures     int
+	LastFailedAt time.Time
+}
+
+// Store persists failure counters. RecordFailure has to be atomic so that
+// several replicas sharing a store can't lose increments: it only records
+// a failure if the key's history is still the one seen, and reports false
+// if another failure was recorded first. Failures recorded before
+// windowStart are forgotten and the count starts over at one. Forgive
+// takes back one failure without moving the window.
+type Store interface {
+	Get(ctx context.Context, key string) (Attempts, error)
+	RecordFailure(ctx context.Context, key string, seen Attempts, failedAt, windowStart time.Time) (Attempts, bool, error)
+	Forgive(ctx context.Context, key string) error
+	Reset(ctx context.Context, key string) error
+}
+
+type Config struct {
+	// MaxFailures is the number of failures after which a key is locked out.
+	MaxFailures int
+	// BaseDelay is the wait after the first failure, doubled for each
+	// further failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Lockout is how long a key stays locked after MaxFailures failures. It
+	// is also how long failures are remembered.
+	Lockout time.Duration
+}
+
+type Limiter struct {
+	store Store
+	cfg   Config
+	now   func() time.Time
+}
+
+func New(store Store, cfg Config) *Limiter {
+	return &Limiter{
+		store: store,
+		cfg:   cfg,
+		now:   time.Now,
+	}
+}
+
+// Result is what the limiter decided about an attempt.
+type Result struct {
+	// Wait is how long the caller has to wait before the attempt is
+	// allowed. Zero means it is.
+	Wait time.Duration
+	// Locked reports whether a key is locked out now that the attempt
+	// counts as a failure, and RetryAfter how long the next attempt has to
+	// wait.
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+// Attempt decides on an attempt from the failures recorded for keys and,
+// if it is allowed, records it for every key before it is made. The
+// attempt counts as a failure until the caller resets or forgives the
+// keys. Refused attempts aren't recorded, so that they can't keep a key
+// locked out or push back its backoff.
+//
+// A key is only recorded if no other attempt was recorded for it since it
+// was read, so concurrent attempts can't all get past the backoff: the
+// ones that lose the race are refused.
+func (l *Limiter) Attempt(ctx context.Context, keys ...string) (Result, error) {
+	now := l.now()
+	var res Result
+	seen := make([]Attempts, len(keys))
+	for i, key := range keys {
+		a, err := l.store.Get(ctx, key)
+		if err != nil {
+			return Result{}, err
+		}
+		seen[i] = a
+		res.Wait = max(res.Wait, l.wait(a, now))
+	}
+	if res.Wait > 0 {
+		return res, nil
+	}
+
+	for i, key := range keys {
+		a, recorded, err := l.store.RecordFailure(ctx, key, seen[i], now, now.Add(-l.cfg.Lockout))
+		if err != nil {
+			return Result{}, err
+		}
+		if !recorded {
+			a, err = l.store.Get(ctx, key)
+			if err != nil {
+				return Result{}, err
+			}
+			return Result{Wait: max(l.wait(a, now), l.cfg.BaseDelay)}, nil
+		}
+		res.RetryAfter = max(res.RetryAfter, l.wait(a, now))
+		if a.Failures >= l.cfg.MaxFailures {
+			res.Locked = true
+		}
+	}
+	return res, nil
+}
+
+// Forgive takes back the failure Attempt recorded for keys, without
+// forgetting the failures before it. It is for keys shared by several
+// users, such as an IP address, which one user's success shouldn't clear.
+func (l *Limiter) Forgive(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := l.store.Forgive(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset forgets the failure history of keys.
+func (l *Limiter) Reset(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := l.store.Reset(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Limiter) wait(a Attempts, now time.Time) time.Duration {
+	if a.Failures == 0 || a.LastFailedAt.Before(now.Add(-l.cfg.Lockout)) {
+		return 0
+	}
+	var until time.Time
+	if a.Failures >= l.cfg.MaxFailures {
+		until = a.LastFailedAt.Add(l.cfg.Lockout)
+	} else {
+		delay := l.cfg.BaseDelay << (a.Failures - 1)
+		if delay <= 0 || delay > l.cfg.MaxDelay {
+			delay = l.cfg.MaxDelay
+		}
+		until = a.LastFailedAt.Add(delay)
+	}
+	if !until.After(now) {
+		return 0
+	}
+	return until.Sub(now)
+}
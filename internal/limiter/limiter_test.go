@@ -0,0 +1,119 @@
+package limiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var testConfig = Config{
+	MaxFailures: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+	Lockout:     15 * time.Minute,
+}
+
+// testLimiter returns a limiter on a memory store whose clock is moved
+// with the returned function.
+func testLimiter() (*Limiter, func(time.Duration)) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := New(NewMemoryStore(), testConfig)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestAttemptBackoff(t *testing.T) {
+	ctx := context.Background()
+	l, advance := testLimiter()
+
+	res, err := l.Attempt(ctx, "email:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Wait != 0 || res.RetryAfter != time.Second || res.Locked {
+		t.Fatalf("first attempt = %+v, want allowed with a retry after 1s", res)
+	}
+
+	res, _ = l.Attempt(ctx, "email:a")
+	if res.Wait != time.Second {
+		t.Fatalf("second attempt straight away waits %v, want 1s", res.Wait)
+	}
+
+	advance(time.Second)
+	res, _ = l.Attempt(ctx, "email:a")
+	if res.Wait != 0 || res.RetryAfter != 2*time.Second {
+		t.Fatalf("second attempt after the backoff = %+v, want allowed with a retry after 2s", res)
+	}
+
+	advance(2 * time.Second)
+	res, _ = l.Attempt(ctx, "email:a")
+	if res.Wait != 0 || !res.Locked || res.RetryAfter != testConfig.Lockout {
+		t.Fatalf("third attempt = %+v, want allowed and locked for %v", res, testConfig.Lockout)
+	}
+}
+
+func TestRefusedAttemptsAreNotRecorded(t *testing.T) {
+	ctx := context.Background()
+	l, advance := testLimiter()
+
+	for range testConfig.MaxFailures {
+		l.Attempt(ctx, "email:victim")
+		advance(testConfig.MaxDelay)
+	}
+	lockedAt := l.now().Add(-testConfig.MaxDelay)
+
+	// Someone keeps trying while the email is locked out.
+	for l.now().Before(lockedAt.Add(testConfig.Lockout)) {
+		res, err := l.Attempt(ctx, "email:victim")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Wait == 0 {
+			t.Fatalf("attempt %v after the lockout started is allowed", l.now().Sub(lockedAt))
+		}
+		advance(time.Minute)
+	}
+
+	res, _ := l.Attempt(ctx, "email:victim")
+	if res.Wait != 0 {
+		t.Errorf("attempt once the lockout is over waits %v, want 0", res.Wait)
+	}
+}
+
+func TestAttemptRace(t *testing.T) {
+	ctx := context.Background()
+	l, _ := testLimiter()
+	seen, _ := l.store.Get(ctx, "email:a")
+
+	// Another attempt is recorded between this one reading the key and
+	// recording it.
+	l.Attempt(ctx, "email:a")
+	_, recorded, err := l.store.RecordFailure(ctx, "email:a", seen, l.now(), l.now().Add(-testConfig.Lockout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded {
+		t.Error("failure recorded over one it didn't see")
+	}
+}
+
+func TestForgive(t *testing.T) {
+	ctx := context.Background()
+	l, advance := testLimiter()
+
+	// Guesses at other accounts from the same address, each followed by
+	// a successful login to the guesser's own account.
+	for range testConfig.MaxFailures {
+		l.Attempt(ctx, "ip:1")
+		advance(testConfig.MaxDelay)
+		if res, _ := l.Attempt(ctx, "ip:1"); res.Wait == 0 {
+			l.Forgive(ctx, "ip:1")
+		}
+		advance(testConfig.MaxDelay)
+	}
+
+	a, _ := l.store.Get(ctx, "ip:1")
+	if a.Failures != testConfig.MaxFailures {
+		t.Errorf("address has %d failures, want %d", a.Failures, testConfig.MaxFailures)
+	}
+}
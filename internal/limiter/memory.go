@@ -0,0 +1,68 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepThreshold is the number of keys at which MemoryStore drops entries
+// that fell out of the window.
+const sweepThreshold = 10000
+
+// MemoryStore keeps counters in process memory. It is the default and is
+// only correct when Chirpy runs as a single instance.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: map[string]Attempts{}}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+func (s *MemoryStore) RecordFailure(ctx context.Context, key string, seen Attempts, failedAt, windowStart time.Time) (Attempts, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.attempts) >= sweepThreshold {
+		for k, a := range s.attempts {
+			if a.LastFailedAt.Before(windowStart) {
+				delete(s.attempts, k)
+			}
+		}
+	}
+	a := s.attempts[key]
+	if a.Failures != seen.Failures || !a.LastFailedAt.Equal(seen.LastFailedAt) {
+		return Attempts{}, false, nil
+	}
+	if a.LastFailedAt.Before(windowStart) {
+		a.Failures = 0
+	}
+	a.Failures++
+	a.LastFailedAt = failedAt
+	s.attempts[key] = a
+	return a, true, nil
+}
+
+func (s *MemoryStore) Forgive(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if a, ok := s.attempts[key]; ok && a.Failures > 0 {
+		a.Failures--
+		s.attempts[key] = a
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
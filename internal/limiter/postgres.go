@@ -0,0 +1,57 @@
+package limiter
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// PostgresStore keeps counters in the login_attempts table so that every
+// replica sees the same failures.
+type PostgresStore struct {
+	db *database.Queries
+}
+
+func NewPostgresStore(db *database.Queries) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) (Attempts, error) {
+	row, err := s.db.GetLoginAttempts(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attempts{}, nil
+	}
+	if err != nil {
+		return Attempts{}, err
+	}
+	return Attempts{Failures: int(row.Failures), LastFailedAt: row.LastFailedAt}, nil
+}
+
+func (s *PostgresStore) RecordFailure(ctx context.Context, key string, seen Attempts, failedAt, windowStart time.Time) (Attempts, bool, error) {
+	row, err := s.db.RecordLoginFailure(ctx, database.RecordLoginFailureParams{
+		Key:              key,
+		FailedAt:         failedAt,
+		WindowStart:      windowStart,
+		SeenFailures:     int32(seen.Failures),
+		SeenLastFailedAt: seen.LastFailedAt,
+	})
+	// The row changed since it was seen.
+	if errors.Is(err, sql.ErrNoRows) {
+		return Attempts{}, false, nil
+	}
+	if err != nil {
+		return Attempts{}, false, err
+	}
+	return Attempts{Failures: int(row.Failures), LastFailedAt: row.LastFailedAt}, true, nil
+}
+
+func (s *PostgresStore) Forgive(ctx context.Context, key string) error {
+	return s.db.ForgiveLoginFailure(ctx, key)
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	return s.db.ResetLoginAttempts(ctx, key)
+}
@@ -0,0 +1,142 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var supportedAlgs = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}
+
+// minRefreshInterval stops a flood of tokens with unknown key ids from
+// turning into a flood of JWKS fetches.
+const minRefreshInterval = time.Minute
+
+type keySet struct {
+	client *http.Client
+	url    string
+
+	mu          sync.Mutex
+	keys        map[string]jwk
+	lastRefresh time.Time
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Alg     string `json:"alg"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func newKeySet(client *http.Client, url string) *keySet {
+	return &keySet{client: client, url: url}
+}
+
+// get returns the public key for kid, refetching the provider's JWKS when
+// the key is unknown, as happens after the provider rotates keys.
+func (s *keySet) get(ctx context.Context, kid, alg string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.lookup(kid)
+	if !ok && time.Since(s.lastRefresh) > minRefreshInterval {
+		var set struct {
+			Keys []jwk `json:"keys"`
+		}
+		err := getJSON(ctx, s.client, s.url, &set)
+		if err != nil {
+			return nil, fmt.Errorf("fetching jwks: %w", err)
+		}
+		s.keys = map[string]jwk{}
+		for _, k := range set.Keys {
+			s.keys[k.KeyID] = k
+		}
+		s.lastRefresh = time.Now()
+		key, ok = s.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %q", kid)
+	}
+	if key.Alg != "" && key.Alg != alg {
+		return nil, fmt.Errorf("key %q is for %s, not %s", kid, key.Alg, alg)
+	}
+	return key.publicKey()
+}
+
+// lookup finds kid, or the only key when the token names none.
+func (s *keySet) lookup(kid string) (jwk, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key length %d", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
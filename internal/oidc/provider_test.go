@@ -0,0 +1,129 @@
+package oidc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/romusking/chirpy/internal/oidc/oidctest"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	t.Helper()
+	server, err := oidctest.NewServer("chirpy", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	provider, err := Discover(context.Background(), Config{
+		Name:         "stub",
+		Issuer:       server.URL,
+		ClientID:     "chirpy",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/api/auth/stub/callback",
+	}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider, server
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	server, err := oidctest.NewServer("chirpy", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	_, err = Discover(context.Background(), Config{
+		Name:   "stub",
+		Issuer: server.URL + "/",
+	}, server.Client())
+	if err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("Discover() error = %v, want issuer mismatch", err)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	user := oidctest.User{Subject: "user-1", Email: "Alice@Example.com", EmailVerified: true}
+
+	tests := []struct {
+		name     string
+		verifier string
+		nonce    string
+		wantErr  string
+	}{
+		{name: "valid", verifier: "verifier", nonce: "nonce"},
+		{name: "PKCE verifier mismatch", verifier: "another-verifier", nonce: "nonce", wantErr: "invalid_grant"},
+		{name: "nonce mismatch", verifier: "verifier", nonce: "another-nonce", wantErr: "nonce mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, server := newTestProvider(t)
+			authURL := provider.AuthCodeURL("state", "nonce", strings.Repeat("v", 43)+"verifier")
+			code, state, err := server.Authorize(authURL, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != "state" {
+				t.Fatalf("Authorize() state = %q, want %q", state, "state")
+			}
+
+			identity, err := provider.Exchange(context.Background(), code, strings.Repeat("v", 43)+tt.verifier, tt.nonce)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Exchange() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			want := Identity{Subject: user.Subject, Email: user.Email, EmailVerified: true}
+			if identity != want {
+				t.Errorf("Exchange() = %+v, want %+v", identity, want)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeReused(t *testing.T) {
+	provider, server := newTestProvider(t)
+	verifier := strings.Repeat("v", 43)
+	code, _, err := server.Authorize(provider.AuthCodeURL("state", "nonce", verifier), oidctest.User{Subject: "user-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provider.Exchange(context.Background(), code, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("first Exchange() error = %v", err)
+	}
+	_, err = provider.Exchange(context.Background(), code, verifier, "nonce")
+	if err == nil {
+		t.Error("second Exchange() with the same code succeeded")
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	provider, _ := newTestProvider(t)
+	verifier := strings.Repeat("v", 43)
+	authURL := provider.AuthCodeURL("the-state", "the-nonce", verifier)
+
+	for _, want := range []string{
+		"state=the-state",
+		"nonce=the-nonce",
+		"code_challenge=" + S256Challenge(verifier),
+		"code_challenge_method=S256",
+		"scope=openid+email",
+	} {
+		if !strings.Contains(authURL, want) {
+			t.Errorf("AuthCodeURL() = %s, missing %s", authURL, want)
+		}
+	}
+	if strings.Contains(authURL, verifier) {
+		t.Errorf("AuthCodeURL() = %s, leaks the PKCE verifier", authURL)
+	}
+}
@@ -0,0 +1,82 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the PostgreSQL notification channel the domain_events table
+// notifies on every insert, with the event's seq as payload. Notifications
+// are only sent once the inserting transaction commits.
+const Channel = "domain_events"
+
+// Listen opens a dedicated connection to dbURL that listens on channel,
+// and returns a channel that receives a value whenever a notification
+// arrives or the connection was re-established, as notifications may
+// have been lost meanwhile. Bursts are coalesced into a single value.
+func Listen(ctx context.Context, dbURL, channel string) (<-chan struct{}, error) {
+	wake := make(chan struct{}, 1)
+	err := listen(ctx, dbURL, channel, func(*pq.Notification) {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wake, nil
+}
+
+// ListenSeqs is like Listen on Channel, but passes on the seq of every
+// committed event in order. A 0 means the connection was re-established
+// and events may have been missed.
+func ListenSeqs(ctx context.Context, dbURL string) (<-chan int64, error) {
+	seqs := make(chan int64, 256)
+	err := listen(ctx, dbURL, Channel, func(n *pq.Notification) {
+		var seq int64
+		if n != nil {
+			seq, _ = strconv.ParseInt(n.Extra, 10, 64)
+		}
+		select {
+		case seqs <- seq:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seqs, nil
+}
+
+// listen calls notify for every notification on channel until ctx is
+// done, with nil after a reconnect.
+func listen(ctx context.Context, dbURL, channel string, notify func(*pq.Notification)) error {
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Error listening on %s: %s", channel, err)
+		}
+	})
+	err := listener.Listen(channel)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				notify(n)
+			}
+		}
+	}()
+	return nil
+}
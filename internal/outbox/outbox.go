@@ -0,0 +1,177 @@
+// Package outbox hands domain events to in-process subscribers. Events
+// are written to the database in the same transaction as the change they
+// describe, so a committed change is always followed by its event and a
+// rolled back one never is.
+//
+// Delivery is at least once: an event whose subscribers fail, or whose
+// dispatcher dies halfway, is handed to every subscriber again later.
+// Subscribers have to tolerate seeing an event twice.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event is a change that has been committed.
+type Event struct {
+	ID uuid.UUID
+	// Seq orders events by when they were written.
+	Seq        int64
+	Type       string
+	UserID     uuid.UUID
+	OccurredAt time.Time
+	Payload    json.RawMessage
+	// Attempts counts earlier dispatches of the event that failed.
+	Attempts int
+}
+
+// Handler reacts to an event. Returning an error makes the dispatcher try
+// the event again later.
+type Handler func(ctx context.Context, ev Event) error
+
+// DeferError is returned by a handler that can't act on an event until
+// a later time, such as the publication of a scheduled chirp. The event is
+// dispatched again at Until instead of after the usual backoff.
+type DeferError struct {
+	Until time.Time
+}
+
+func (e *DeferError) Error() string {
+	return "outbox: event deferred until " + e.Until.Format(time.RFC3339)
+}
+
+// Store is where events wait to be dispatched.
+type Store interface {
+	// Claim leases up to limit due events until leaseUntil, so that other
+	// dispatchers skip them meanwhile.
+	Claim(ctx context.Context, limit int, leaseUntil time.Time) ([]Event, error)
+	MarkDispatched(ctx context.Context, id uuid.UUID) error
+	// MarkFailed makes the event due again at retryAt.
+	MarkFailed(ctx context.Context, id uuid.UUID, retryAt time.Time, reason string) error
+}
+
+type Config struct {
+	// PollInterval is how often the store is checked when no
+	// notification arrives.
+	PollInterval time.Duration
+	BatchSize    int
+	// Lease must be longer than the subscribers take to handle a batch.
+	Lease time.Duration
+	// A failed event is retried after RetryBase, doubled on every further
+	// failure up to RetryMax.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+}
+
+var DefaultConfig = Config{
+	PollInterval: 5 * time.Second,
+	BatchSize:    100,
+	Lease:        time.Minute,
+	RetryBase:    5 * time.Second,
+	RetryMax:     time.Hour,
+}
+
+type subscriber struct {
+	types  []string
+	handle Handler
+}
+
+// Dispatcher takes events from a Store and calls the subscribers
+// interested in them.
+type Dispatcher struct {
+	store       Store
+	cfg         Config
+	subscribers []subscriber
+}
+
+func NewDispatcher(store Store, cfg Config) *Dispatcher {
+	return &Dispatcher{store: store, cfg: cfg}
+}
+
+// Subscribe calls h for every event of one of eventTypes, or for every
+// event if none are given. Subscribers are added before Run.
+func (d *Dispatcher) Subscribe(h Handler, eventTypes ...string) {
+	d.subscribers = append(d.subscribers, subscriber{types: eventTypes, handle: h})
+}
+
+// Run dispatches events whenever wake fires, and every PollInterval in
+// case a notification was missed, until ctx is done. wake may be nil.
+func (d *Dispatcher) Run(ctx context.Context, wake <-chan struct{}) {
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		err := d.DispatchPending(ctx)
+		if err != nil {
+			log.Printf("Error dispatching domain events: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// DispatchPending dispatches every event that is due.
+func (d *Dispatcher) DispatchPending(ctx context.Context) error {
+	for {
+		events, err := d.store.Claim(ctx, d.cfg.BatchSize, time.Now().Add(d.cfg.Lease))
+		if err != nil {
+			return err
+		}
+		sort.Slice(events, func(i, j int) bool { return events[i].Seq < events[j].Seq })
+
+		for _, ev := range events {
+			err = d.dispatch(ctx, ev)
+			var deferErr *DeferError
+			if errors.As(err, &deferErr) {
+				err = d.store.MarkFailed(ctx, ev.ID, deferErr.Until, err.Error())
+			} else if err != nil {
+				err = d.store.MarkFailed(ctx, ev.ID, time.Now().Add(d.retryDelay(ev.Attempts+1)), err.Error())
+			} else {
+				err = d.store.MarkDispatched(ctx, ev.ID)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(events) < d.cfg.BatchSize {
+			return nil
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, ev Event) error {
+	var errs []error
+	for _, sub := range d.subscribers {
+		if len(sub.types) > 0 && !slices.Contains(sub.types, ev.Type) {
+			continue
+		}
+		err := sub.handle(ctx, ev)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Dispatcher) retryDelay(failures int) time.Duration {
+	delay := d.cfg.RetryBase
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= d.cfg.RetryMax {
+			return d.cfg.RetryMax
+		}
+	}
+	return delay
+}
@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// PostgresStore reads events from the domain_events table. Several
+// dispatchers can share it; each event is leased to one at a time.
+type PostgresStore struct {
+	db *database.Queries
+}
+
+func NewPostgresStore(db *database.Queries) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Claim(ctx context.Context, limit int, leaseUntil time.Time) ([]Event, error) {
+	rows, err := s.db.ClaimDomainEvents(ctx, database.ClaimDomainEventsParams{
+		LeaseUntil: leaseUntil.UTC(),
+		Limit:      int32(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, len(rows))
+	for i, row := range rows {
+		events[i] = Event{
+			ID:         row.ID,
+			Seq:        row.Seq,
+			Type:       row.Type,
+			UserID:     row.UserID,
+			OccurredAt: row.OccurredAt,
+			Payload:    row.Payload,
+			Attempts:   int(row.Attempts),
+		}
+	}
+	return events, nil
+}
+
+func (s *PostgresStore) MarkDispatched(ctx context.Context, id uuid.UUID) error {
+	return s.db.MarkDomainEventDispatched(ctx, id)
+}
+
+func (s *PostgresStore) MarkFailed(ctx context.Context, id uuid.UUID, retryAt time.Time, reason string) error {
+	return s.db.FailDomainEvent(ctx, database.FailDomainEventParams{
+		ID:            id,
+		NextAttemptAt: retryAt.UTC(),
+		LastError:     sql.NullString{String: reason, Valid: true},
+	})
+}
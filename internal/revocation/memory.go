@@ -0,0 +1,60 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryDenylist is only correct when Chirpy runs as a single instance.
+type MemoryDenylist struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time
+	cutoffs map[uuid.UUID]time.Time
+}
+
+func NewMemoryDenylist() *MemoryDenylist {
+	return &MemoryDenylist{
+		revoked: map[string]time.Time{},
+		cutoffs: map[uuid.UUID]time.Time{},
+	}
+}
+
+func (d *MemoryDenylist) Revoke(ctx context.Context, id string, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for k, exp := range d.revoked {
+		if !exp.After(now) {
+			delete(d.revoked, k)
+		}
+	}
+	if expiresAt.After(d.revoked[id]) {
+		d.revoked[id] = expiresAt
+	}
+	return nil
+}
+
+func (d *MemoryDenylist) IsRevoked(ctx context.Context, id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	exp, ok := d.revoked[id]
+	return ok && exp.After(time.Now()), nil
+}
+
+func (d *MemoryDenylist) RevokeUserBefore(ctx context.Context, userID uuid.UUID, t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t.After(d.cutoffs[userID]) {
+		d.cutoffs[userID] = t
+	}
+	return nil
+}
+
+func (d *MemoryDenylist) UserCutoff(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cutoffs[userID], nil
+}
@@ -0,0 +1,52 @@
+package revocation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// PostgresDenylist shares revocations between replicas through the
+// revoked_tokens and user_token_cutoffs tables.
+type PostgresDenylist struct {
+	db *database.Queries
+}
+
+func NewPostgresDenylist(db *database.Queries) *PostgresDenylist {
+	return &PostgresDenylist{db: db}
+}
+
+func (d *PostgresDenylist) Revoke(ctx context.Context, id string, expiresAt time.Time) error {
+	// Revocations are rare, so this is a cheap place to drop stale rows.
+	err := d.db.DeleteExpiredRevokedTokens(ctx)
+	if err != nil {
+		return err
+	}
+	return d.db.RevokeToken(ctx, database.RevokeTokenParams{
+		ID:        id,
+		ExpiresAt: expiresAt,
+	})
+}
+
+func (d *PostgresDenylist) IsRevoked(ctx context.Context, id string) (bool, error) {
+	return d.db.IsTokenRevoked(ctx, id)
+}
+
+func (d *PostgresDenylist) RevokeUserBefore(ctx context.Context, userID uuid.UUID, t time.Time) error {
+	return d.db.SetUserTokenCutoff(ctx, database.SetUserTokenCutoffParams{
+		UserID:    userID,
+		NotBefore: t,
+	})
+}
+
+func (d *PostgresDenylist) UserCutoff(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	cutoff, err := d.db.GetUserTokenCutoff(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return cutoff, err
+}
@@ -0,0 +1,30 @@
+// Package revocation keeps track of access tokens that have to be refused
+// before they expire, for example after a logout or a password change.
+package revocation
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Denylist records revoked token identifiers and per-user cutoffs.
+// Identifiers are opaque; callers namespace them (a jti, a session id).
+type Denylist interface {
+	// Revoke refuses id until expiresAt, after which the token would have
+	// expired anyway and the entry can be dropped.
+	Revoke(ctx context.Context, id string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, id string) (bool, error)
+	// RevokeUserBefore refuses every token of userID issued before t.
+	RevokeUserBefore(ctx context.Context, userID uuid.UUID, t time.Time) error
+	// UserCutoff returns the time set by RevokeUserBefore, or the zero time.
+	UserCutoff(ctx context.Context, userID uuid.UUID) (time.Time, error)
+}
+
+// IssuedBeforeCutoff reports whether a token issued at issuedAt falls
+// under cutoff. Token timestamps only have second precision, so the cutoff
+// is truncated the same way.
+func IssuedBeforeCutoff(issuedAt, cutoff time.Time) bool {
+	return issuedAt.Before(cutoff.Truncate(time.Second))
+}
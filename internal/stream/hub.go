@@ -0,0 +1,77 @@
+// Package stream fans events out to live connections, such as
+// server-sent event streams and WebSockets.
+package stream
+
+import (
+	"sync"
+
+	"github.com/romusking/chirpy/internal/outbox"
+)
+
+// Hub passes every published event to every subscription. A subscriber
+// that falls more than its buffer behind is dropped rather than allowed
+// to hold up the others; it can reconnect and catch up from the outbox.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	buffer int
+}
+
+func NewHub(buffer int) *Hub {
+	return &Hub{
+		subs:   map[*Subscription]struct{}{},
+		buffer: buffer,
+	}
+}
+
+type Subscription struct {
+	hub    *Hub
+	events chan outbox.Event
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (h *Hub) Subscribe() *Subscription {
+	sub := &Subscription{
+		hub:    h,
+		events: make(chan outbox.Event, h.buffer),
+		done:   make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// Publish hands ev to every subscription without blocking.
+func (h *Hub) Publish(ev outbox.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		select {
+		case sub.events <- ev:
+		default:
+			delete(h.subs, sub)
+			sub.once.Do(func() { close(sub.done) })
+		}
+	}
+}
+
+// Events delivers the published events in order.
+func (s *Subscription) Events() <-chan outbox.Event {
+	return s.events
+}
+
+// Done is closed once the subscription has been closed or dropped for
+// being too slow. Events still buffered can be read afterwards but no
+// more arrive.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	delete(s.hub.subs, s)
+	s.hub.mu.Unlock()
+	s.once.Do(func() { close(s.done) })
+}
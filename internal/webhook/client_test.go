@@ -0,0 +1,86 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{addr: "93.184.215.14", want: true},
+		{addr: "2606:4700::6810:84e5", want: true},
+		{addr: "127.0.0.1", want: false},
+		{addr: "::1", want: false},
+		{addr: "0.0.0.0", want: false},
+		{addr: "::", want: false},
+		{addr: "10.1.2.3", want: false},
+		{addr: "172.16.0.1", want: false},
+		{addr: "192.168.1.1", want: false},
+		{addr: "169.254.169.254", want: false},
+		{addr: "fe80::1", want: false},
+		{addr: "fd00::1", want: false},
+		{addr: "100.64.0.1", want: false},
+		{addr: "224.0.0.1", want: false},
+		{addr: "255.255.255.255", want: false},
+		{addr: "::ffff:127.0.0.1", want: false},
+		{addr: "::ffff:169.254.169.254", want: false},
+		{addr: "64:ff9b::a9fe:a9fe", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := IsPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+				t.Errorf("IsPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientRefusesLoopback(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	_, err := Send(context.Background(), NewClient(time.Second), server.URL, "evt_1", []byte("key"), []byte(`{}`))
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("Send() to %s error = %v, want %v", server.URL, err, ErrForbiddenAddress)
+	}
+	if called {
+		t.Error("receiver on loopback was called")
+	}
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	redirected := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			redirected = true
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	// The loopback receiver needs the default transport; the redirect
+	// policy is the client's own.
+	client := NewClient(time.Second)
+	client.Transport = server.Client().Transport
+
+	status, err := Send(context.Background(), client, server.URL, "evt_1", []byte("key"), []byte(`{}`))
+	if err == nil || status != http.StatusTemporaryRedirect {
+		t.Errorf("Send() = %d, %v, want %d and an error", status, err, http.StatusTemporaryRedirect)
+	}
+	if redirected {
+		t.Error("redirect was followed")
+	}
+}
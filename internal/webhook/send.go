@@ -0,0 +1,38 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxResponseBody bounds how much of a receiver's response is read before
+// the connection is reused.
+const maxResponseBody = 64 << 10
+
+// Send posts body to url, signed with key and identified by id. It returns
+// the status the receiver answered with, and an error unless that status
+// is 2xx.
+func Send(ctx context.Context, client *http.Client, url, id string, key, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	SetHeaders(req.Header, id, body, time.Now(), key)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook: receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	key := []byte("whsec_test")
+	body := []byte(`{"id":"evt_1","type":"chirp.created"}`)
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "no content", status: http.StatusNoContent},
+		{name: "server error", status: http.StatusInternalServerError, wantErr: true},
+		{name: "gone", status: http.StatusGone, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := NewVerifier([][]byte{key}, 5*time.Minute)
+			var received http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r.Header.Clone()
+				got, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Error(err)
+				}
+				if string(got) != string(body) {
+					t.Errorf("receiver got body %s, want %s", got, body)
+				}
+				if err := verifier.Verify(r.Header, got); err != nil {
+					t.Errorf("receiver couldn't verify delivery: %v", err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			status, err := Send(context.Background(), server.Client(), server.URL, "evt_1", key, body)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Send() error = %v, want error %v", err, tt.wantErr)
+			}
+			if status != tt.status {
+				t.Errorf("Send() status = %d, want %d", status, tt.status)
+			}
+			if received.Get(IDHeader) != "evt_1" {
+				t.Errorf("%s = %q, want %q", IDHeader, received.Get(IDHeader), "evt_1")
+			}
+			if received.Get(TimestampHeader) == "" || received.Get(SignatureHeader) == "" {
+				t.Errorf("delivery isn't signed: %v", received)
+			}
+			if received.Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", received.Get("Content-Type"))
+			}
+		})
+	}
+}
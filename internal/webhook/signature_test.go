@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	oldKey := []byte("old-polka-key")
+	newKey := []byte("new-polka-key")
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+
+	// signed is a request from a fake Polka sender, changed by edit.
+	signed := func(edit func(h http.Header, body []byte) []byte, keys ...[]byte) (http.Header, []byte) {
+		h := http.Header{}
+		b := append([]byte(nil), body...)
+		SetHeaders(h, "evt_1", b, now, keys...)
+		if edit != nil {
+			b = edit(h, b)
+		}
+		return h, b
+	}
+
+	tests := []struct {
+		name    string
+		keys    [][]byte
+		signers [][]byte
+		edit    func(h http.Header, body []byte) []byte
+		wantErr error
+	}{
+		{
+			name:    "valid",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+		},
+		{
+			name:    "rotation signed with old key",
+			keys:    [][]byte{oldKey, newKey},
+			signers: [][]byte{oldKey},
+		},
+		{
+			name:    "rotation signed with new key",
+			keys:    [][]byte{oldKey, newKey},
+			signers: [][]byte{newKey},
+		},
+		{
+			name:    "rotation signed with both keys",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{oldKey, newKey},
+		},
+		{
+			name:    "retired key",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{oldKey},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "stale timestamp",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				ts := now.Add(-6 * time.Minute)
+				SetHeaders(h, "evt_1", body, ts, newKey)
+				return body
+			},
+			wantErr: ErrInvalidTimestamp,
+		},
+		{
+			name:    "future timestamp",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				ts := now.Add(6 * time.Minute)
+				SetHeaders(h, "evt_1", body, ts, newKey)
+				return body
+			},
+			wantErr: ErrInvalidTimestamp,
+		},
+		{
+			name:    "timestamp changed after signing",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				h.Set(TimestampHeader, strconv.FormatInt(now.Unix()+1, 10))
+				return body
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "tampered body",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				return []byte(`{"event":"user.upgraded","data":{"user_id":"00000000-0000-0000-0000-000000000000"}}`)
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "id changed after signing",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				h.Set(IDHeader, "evt_2")
+				return body
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "missing signature",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				h.Del(SignatureHeader)
+				return body
+			},
+			wantErr: ErrNoSignature,
+		},
+		{
+			name:    "missing timestamp",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				h.Del(TimestampHeader)
+				return body
+			},
+			wantErr: ErrInvalidTimestamp,
+		},
+		{
+			name:    "malformed timestamp",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				h.Set(TimestampHeader, "yesterday")
+				return body
+			},
+			wantErr: ErrInvalidTimestamp,
+		},
+		{
+			name:    "malformed signature",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				h.Set(SignatureHeader, "v1=not-hex")
+				return body
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "signature without version",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				h.Set(SignatureHeader, Sign(newKey, "evt_1", now, body))
+				return body
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "unknown version",
+			keys:    [][]byte{newKey},
+			signers: [][]byte{newKey},
+			edit: func(h http.Header, body []byte) []byte {
+				h.Set(SignatureHeader, "v2="+Sign(newKey, "evt_1", now, body))
+				return body
+			},
+			wantErr: ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewVerifier(tt.keys, 5*time.Minute)
+			v.now = func() time.Time { return now }
+
+			h, b := signed(tt.edit, tt.signers...)
+			err := v.Verify(h, b)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,7 +3,10 @@ package main
 import (
 	"encoding/json"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
@@ -21,6 +24,12 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	})
 }
 
+func respondWithRetryAfter(w http.ResponseWriter, wait time.Duration, msg string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithError(w, http.StatusTooManyRequests, msg, nil)
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/romusking/chirpy/internal/auth"
+)
+
+const (
+	defaultJWTIssuer   = "chirpy"
+	defaultJWTAudience = "chirpy-api"
+	defaultJWTLeeway   = 30 * time.Second
+)
+
+// loadJWTConfig reads JWT_ISSUER, JWT_AUDIENCE and JWT_LEEWAY (a Go
+// duration) on top of the keyring.
+func loadJWTConfig() (auth.JWTConfig, error) {
+	keys, err := loadKeyring()
+	if err != nil {
+		return auth.JWTConfig{}, err
+	}
+	cfg := auth.JWTConfig{
+		Keys:     keys,
+		Issuer:   defaultJWTIssuer,
+		Audience: defaultJWTAudience,
+		Leeway:   defaultJWTLeeway,
+	}
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		cfg.Issuer = issuer
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		cfg.Audience = audience
+	}
+	if leeway := os.Getenv("JWT_LEEWAY"); leeway != "" {
+		cfg.Leeway, err = time.ParseDuration(leeway)
+		if err != nil {
+			return auth.JWTConfig{}, fmt.Errorf("JWT_LEEWAY: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// legacyKeyID names the HS256 key built from SECRET. Tokens issued before
+// keys had ids are verified with it.
+const legacyKeyID = "secret"
+
+// loadKeyring builds the JWT keyring from the environment. Without
+// JWT_SIGNING_KEY_FILE tokens are signed with SECRET as before. With it,
+// tokens are signed with that RSA or Ed25519 key, and SECRET (if still set)
+// only verifies tokens issued before the switch. JWT_VERIFY_KEYS lists
+// retired public keys as comma-separated kid:path pairs.
+func loadKeyring() (*auth.Keyring, error) {
+	var verifyOnly []auth.Key
+	for _, entry := range strings.Split(os.Getenv("JWT_VERIFY_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("JWT_VERIFY_KEYS entry %q is not kid:path", entry)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := auth.ParsePublicKeyPEM(kid, data)
+		if err != nil {
+			return nil, err
+		}
+		verifyOnly = append(verifyOnly, key)
+	}
+
+	secret := os.Getenv("SECRET")
+	var secretKey *auth.Key
+	if secret != "" {
+		key := auth.NewHMACKey(legacyKeyID, []byte(secret))
+		secretKey = &key
+	}
+
+	var signing auth.Key
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		kid := os.Getenv("JWT_SIGNING_KEY_ID")
+		if kid == "" {
+			kid = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		signing, err = auth.ParsePrivateKeyPEM(kid, data)
+		if err != nil {
+			return nil, err
+		}
+		if secretKey != nil {
+			verifyOnly = append(verifyOnly, *secretKey)
+		}
+	} else if secretKey != nil {
+		signing = *secretKey
+	} else {
+		return nil, errors.New("neither JWT_SIGNING_KEY_FILE nor SECRET is set")
+	}
+
+	keys, err := auth.NewKeyring(signing, verifyOnly...)
+	if err != nil {
+		return nil, err
+	}
+	if secretKey != nil {
+		err = keys.SetLegacyKey(legacyKeyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, cfg.jwtCfg.Keys.JWKS())
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// likeChirp likes a chirp for the caller and responds with how many likes
+// it has. Liking a chirp twice counts once.
+func (cfg *apiConfig) likeChirp(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't like chirp, wrong UUID.", err)
+		return
+	}
+
+	chirpInDB, err := cfg.db.GetOneChirp(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Chirp doesn't exist.", err)
+		return
+	}
+	if chirpInDB.PublishedAt.After(time.Now()) && userID != chirpInDB.UserID {
+		respondWithError(w, http.StatusNotFound, "Chirp doesn't exist.", nil)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't like chirp, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	liked, err := qtx.LikeChirp(r.Context(), database.LikeChirpParams{
+		UserID:  userID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't like chirp, database error.", err)
+		return
+	}
+
+	if liked > 0 {
+		err = publishEventTx(r.Context(), qtx, eventChirpLiked, userID, map[string]any{
+			"chirp_id":  chirpID,
+			"user_id":   userID,
+			"author_id": chirpInDB.UserID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't like chirp, database error.", err)
+			return
+		}
+	}
+
+	likes, err := qtx.CountChirpLikes(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't like chirp, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't like chirp, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirpLikes{ChirpID: chirpID, Likes: likes})
+}
+
+func (cfg *apiConfig) unlikeChirp(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't unlike chirp, wrong UUID.", err)
+		return
+	}
+
+	_, err = cfg.db.UnlikeChirp(r.Context(), database.UnlikeChirpParams{
+		UserID:  principal.UserID,
+		ChirpID: chirpID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlike chirp, database error.", err)
+		return
+	}
+
+	likes, err := cfg.db.CountChirpLikes(r.Context(), chirpID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlike chirp, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, chirpLikes{ChirpID: chirpID, Likes: likes})
+}
+
+type chirpLikes struct {
+	ChirpID uuid.UUID `json:"chirp_id"`
+	Likes   int64     `json:"likes"`
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/romusking/chirpy/internal/auth"
 	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/entitlements"
+	"github.com/romusking/chirpy/internal/limiter"
+	"github.com/romusking/chirpy/internal/outbox"
+	"github.com/romusking/chirpy/internal/revocation"
+	"github.com/romusking/chirpy/internal/webhook"
 )
 
 func main() {
@@ -20,16 +28,79 @@ func main() {
 	}
 	platform := os.Getenv("PLATFORM")
 
-	secret := os.Getenv("SECRET")
+	jwtCfg, err := loadJWTConfig()
+	if err != nil {
+		log.Fatalf("Error loading JWT keys: %s", err)
+	}
 
 	polkaKey := os.Getenv("POLKA_KEY")
+	polkaVerifier, err := loadPolkaVerifier()
+	if err != nil {
+		log.Fatalf("Error loading Polka signing keys: %s", err)
+	}
+
+	queries := database.New(db)
+
+	var limiterStore limiter.Store = limiter.NewMemoryStore()
+	if os.Getenv("LOGIN_LIMITER_STORE") == "postgres" {
+		limiterStore = limiter.NewPostgresStore(queries)
+	}
+	loginLimiter := limiter.New(limiterStore, limiter.Config{
+		MaxFailures: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+		Lockout:     15 * time.Minute,
+	})
+
+	var denylist revocation.Denylist = revocation.NewMemoryDenylist()
+	if os.Getenv("TOKEN_DENYLIST_STORE") == "postgres" {
+		denylist = revocation.NewPostgresDenylist(queries)
+	}
+
+	entitlementsCfg := entitlements.DefaultConfig
+	if s := os.Getenv("ENTITLEMENTS"); s != "" {
+		entitlementsCfg, err = entitlements.ParseConfig(s)
+		if err != nil {
+			log.Fatalf("Error loading entitlements: %s", err)
+		}
+	}
 
 	apiCfg := apiConfig{
-		db:       database.New(db),
-		platform: platform,
-		secret:   secret,
-		polkaKey: polkaKey,
+		conn:          db,
+		db:            queries,
+		platform:      platform,
+		jwtCfg:        jwtCfg,
+		polkaKey:      polkaKey,
+		polkaVerifier: polkaVerifier,
+		webhookClient: webhook.NewClient(webhookDeliveryTimeout),
+		loginLimiter:  loginLimiter,
+		denylist:      denylist,
+		oidcProviders: loadOIDCProviders(context.Background()),
+		entitlements:  entitlements.New(entitlementsCfg, entitlements.NewPostgresSource(queries)),
+		chirpFeed:     newChirpFeed(queries),
+
+		bootstrapAdminToken: os.Getenv("BOOTSTRAP_ADMIN_TOKEN"),
+	}
+
+	go apiCfg.expireSubscriptions(context.Background(), subscriptionExpiryInterval)
+
+	dispatcher := outbox.NewDispatcher(outbox.NewPostgresStore(queries), outbox.DefaultConfig)
+	dispatcher.Subscribe(apiCfg.enqueueWebhookDeliveries, webhookEventTypes...)
+	dispatcher.Subscribe(apiCfg.announceChirp, eventChirpCreated)
+	dispatcher.Subscribe(apiCfg.createNotifications, eventChirpCreated, eventChirpLiked, eventUserFollowed, eventUserUpgraded)
+	wake, err := outbox.Listen(context.Background(), dbURL, outbox.Channel)
+	if err != nil {
+		log.Printf("Error listening for domain events, polling instead: %s", err)
+	}
+	go dispatcher.Run(context.Background(), wake)
+
+	go apiCfg.deliverWebhooks(context.Background(), webhookDeliveryInterval)
+
+	seqs, err := outbox.ListenSeqs(context.Background(), dbURL)
+	if err != nil {
+		log.Printf("Error listening for chirp events, polling instead: %s", err)
 	}
+	go apiCfg.chirpFeed.run(context.Background(), seqs, outbox.DefaultConfig.PollInterval)
 
 	mux := http.NewServeMux()
 	s := &http.Server{
@@ -39,29 +110,127 @@ func main() {
 	}
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
 
-	mux.HandleFunc("GET /admin/metrics", apiCfg.middlewareMetricsDsp)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.handlerJWKS)
+
+	mux.Handle("POST /api/admin/bootstrap", apiCfg.middlewareRequireAuth(apiCfg.bootstrapAdmin, auth.ScopeAccount))
+
+	mux.Handle("GET /admin/metrics", apiCfg.middlewareAdmin(apiCfg.middlewareMetricsDsp))
+
+	mux.Handle("POST /admin/reset", apiCfg.middlewareAdmin(apiCfg.resetUserDB))
+
+	mux.Handle("GET /admin/users", apiCfg.middlewareAdmin(apiCfg.adminListUsers))
+
+	mux.Handle("GET /admin/users/{userID}", apiCfg.middlewareAdmin(apiCfg.adminGetUser))
+
+	mux.Handle("PUT /admin/users/{userID}/role", apiCfg.middlewareAdmin(apiCfg.setUserRole))
+
+	mux.Handle("POST /admin/users/{userID}/suspend", apiCfg.middlewareAdmin(apiCfg.adminSuspendUser))
+
+	mux.Handle("POST /admin/users/{userID}/unsuspend", apiCfg.middlewareAdmin(apiCfg.adminUnsuspendUser))
 
-	mux.HandleFunc("POST /admin/reset", apiCfg.resetUserDB)
+	mux.Handle("POST /admin/users/{userID}/logout", apiCfg.middlewareAdmin(apiCfg.adminLogoutUser))
 
-	mux.HandleFunc("POST /api/reset", apiCfg.middlewareMetricsRst)
+	mux.Handle("PUT /admin/users/{userID}/red", apiCfg.middlewareAdmin(apiCfg.adminSetChirpyRed))
+
+	mux.Handle("GET /admin/audit-events", apiCfg.middlewareAdmin(apiCfg.adminListAuditEvents))
+
+	mux.Handle("GET /admin/webhook-events", apiCfg.middlewareAdmin(apiCfg.adminListWebhookEvents))
+
+	mux.Handle("POST /admin/webhook-events/{eventID}/replay", apiCfg.middlewareAdmin(apiCfg.adminReplayWebhookEvent))
+
+	mux.Handle("POST /admin/metrics/reset", apiCfg.middlewareAdmin(apiCfg.middlewareMetricsRst))
 
 	mux.HandleFunc("POST /api/users", apiCfg.createUser)
 
-	mux.HandleFunc("PUT /api/users", apiCfg.updateUser)
+	mux.Handle("PUT /api/users", apiCfg.middlewareRequireAuth(apiCfg.updateUser, auth.ScopeAccount))
 
 	mux.HandleFunc("POST /api/login", apiCfg.loginUser)
 
+	mux.HandleFunc("POST /api/login/2fa", apiCfg.loginSecondFactor)
+
+	mux.Handle("POST /api/users/2fa", apiCfg.middlewareRequireAuth(apiCfg.enrollTOTP, auth.ScopeAccount))
+
+	mux.Handle("POST /api/users/2fa/confirm", apiCfg.middlewareRequireAuth(apiCfg.confirmTOTP, auth.ScopeAccount))
+
+	mux.HandleFunc("GET /api/auth/{provider}/login", apiCfg.oidcLogin)
+
+	mux.HandleFunc("GET /api/auth/{provider}/callback", apiCfg.oidcCallback)
+
 	mux.HandleFunc("POST /api/refresh", apiCfg.refreshToken)
 
 	mux.HandleFunc("POST /api/revoke", apiCfg.revokeRefreshToken)
 
-	mux.HandleFunc("POST /api/chirps", apiCfg.createChirp)
+	mux.Handle("POST /api/logout", apiCfg.middlewareRequireAuth(apiCfg.logout))
+
+	mux.Handle("GET /api/sessions", apiCfg.middlewareRequireAuth(apiCfg.listSessions, auth.ScopeAccount))
+
+	mux.Handle("DELETE /api/sessions/{sessionID}", apiCfg.middlewareRequireAuth(apiCfg.deleteSession, auth.ScopeAccount))
+
+	mux.Handle("POST /api/sessions/revoke-all", apiCfg.middlewareRequireAuth(apiCfg.revokeAllUserSessions, auth.ScopeAccount))
+
+	mux.Handle("POST /api/tokens", apiCfg.middlewareRequireAuth(apiCfg.createPersonalToken, auth.ScopeAccount))
+
+	mux.Handle("GET /api/tokens", apiCfg.middlewareRequireAuth(apiCfg.listPersonalTokens, auth.ScopeAccount))
+
+	mux.Handle("DELETE /api/tokens/{tokenID}", apiCfg.middlewareRequireAuth(apiCfg.revokePersonalToken, auth.ScopeAccount))
+
+	mux.Handle("GET /api/notifications", apiCfg.middlewareRequireAuth(apiCfg.listNotifications, auth.ScopeAccount))
+
+	mux.Handle("POST /api/notifications/read", apiCfg.middlewareRequireAuth(apiCfg.markAllNotificationsRead, auth.ScopeAccount))
+
+	mux.Handle("POST /api/notifications/{notificationID}/read", apiCfg.middlewareRequireAuth(apiCfg.markNotificationRead, auth.ScopeAccount))
+
+	mux.Handle("GET /api/notifications/preferences", apiCfg.middlewareRequireAuth(apiCfg.listNotificationPreferences, auth.ScopeAccount))
+
+	mux.Handle("PUT /api/notifications/preferences/{type}", apiCfg.middlewareRequireAuth(apiCfg.setNotificationPreference, auth.ScopeAccount))
+
+	mux.Handle("POST /api/conversations", apiCfg.middlewareRequireAuth(apiCfg.startConversation, auth.ScopeAccount))
+
+	mux.Handle("GET /api/conversations", apiCfg.middlewareRequireAuth(apiCfg.listConversations, auth.ScopeAccount))
+
+	mux.Handle("GET /api/conversations/{conversationID}/messages", apiCfg.middlewareRequireAuth(apiCfg.listDirectMessages, auth.ScopeAccount))
+
+	mux.Handle("POST /api/conversations/{conversationID}/messages", apiCfg.middlewareRequireAuth(apiCfg.sendDirectMessage, auth.ScopeAccount))
+
+	mux.Handle("POST /api/conversations/{conversationID}/read", apiCfg.middlewareRequireAuth(apiCfg.markConversationRead, auth.ScopeAccount))
+
+	mux.Handle("GET /api/blocks", apiCfg.middlewareRequireAuth(apiCfg.listBlockedUsers, auth.ScopeAccount))
+
+	mux.Handle("POST /api/blocks", apiCfg.middlewareRequireAuth(apiCfg.blockUser, auth.ScopeAccount))
+
+	mux.Handle("DELETE /api/blocks/{userID}", apiCfg.middlewareRequireAuth(apiCfg.unblockUser, auth.ScopeAccount))
+
+	mux.Handle("POST /api/users/{userID}/follow", apiCfg.middlewareRequireAuth(apiCfg.followUser, auth.ScopeAccount))
+
+	mux.Handle("DELETE /api/users/{userID}/follow", apiCfg.middlewareRequireAuth(apiCfg.unfollowUser, auth.ScopeAccount))
+
+	mux.Handle("POST /api/webhooks", apiCfg.middlewareRequireAuth(apiCfg.createWebhookSubscription, auth.ScopeAccount))
+
+	mux.Handle("GET /api/webhooks", apiCfg.middlewareRequireAuth(apiCfg.listWebhookSubscriptions, auth.ScopeAccount))
+
+	mux.Handle("DELETE /api/webhooks/{webhookID}", apiCfg.middlewareRequireAuth(apiCfg.deleteWebhookSubscription, auth.ScopeAccount))
+
+	mux.Handle("GET /api/webhooks/{webhookID}/deliveries", apiCfg.middlewareRequireAuth(apiCfg.listWebhookDeliveries, auth.ScopeAccount))
+
+	mux.Handle("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/retry", apiCfg.middlewareRequireAuth(apiCfg.retryWebhookDelivery, auth.ScopeAccount))
+
+	mux.Handle("POST /api/chirps", apiCfg.middlewareRequireAuth(apiCfg.createChirp, auth.ScopeChirpsWrite))
+
+	mux.Handle("GET /api/chirps", apiCfg.middlewareOptionalAuth(apiCfg.getAllChirps))
+
+	mux.Handle("GET /api/chirps/stream", apiCfg.middlewareOptionalAuth(apiCfg.streamChirps))
+
+	mux.Handle("GET /api/ws", middlewareSocketToken(apiCfg.middlewareRequireAuth(apiCfg.handleSocket)))
+
+	mux.Handle("PUT /api/chirps/{chirpID}", apiCfg.middlewareRequireAuth(apiCfg.updateChirp, auth.ScopeChirpsWrite))
+
+	mux.Handle("DELETE /api/chirps/{chirpID}", apiCfg.middlewareRequireAuth(apiCfg.deleteAChirp, auth.ScopeChirpsWrite))
 
-	mux.HandleFunc("GET /api/chirps", apiCfg.getAllChirps)
+	mux.Handle("GET /api/chirps/{chirpID}", apiCfg.middlewareOptionalAuth(apiCfg.getOneChirp))
 
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.deleteAChirp)
+	mux.Handle("POST /api/chirps/{chirpID}/likes", apiCfg.middlewareRequireAuth(apiCfg.likeChirp, auth.ScopeChirpsWrite))
 
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.getOneChirp)
+	mux.Handle("DELETE /api/chirps/{chirpID}/likes", apiCfg.middlewareRequireAuth(apiCfg.unlikeChirp, auth.ScopeChirpsWrite))
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.makeUserRed)
 
@@ -1,19 +1,38 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"net/http"
 	"sync/atomic"
 
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
 	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/entitlements"
+	"github.com/romusking/chirpy/internal/limiter"
+	"github.com/romusking/chirpy/internal/oidc"
+	"github.com/romusking/chirpy/internal/revocation"
+	"github.com/romusking/chirpy/internal/webhook"
 )
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	conn           *sql.DB
 	db             *database.Queries
 	platform       string
-	secret         string
+	jwtCfg         auth.JWTConfig
 	polkaKey       string
+	polkaVerifier  *webhook.Verifier
+	webhookClient  *http.Client
+	loginLimiter   *limiter.Limiter
+	denylist       revocation.Denylist
+	oidcProviders  map[string]*oidc.Provider
+	entitlements   *entitlements.Service
+	chirpFeed      *chirpFeed
+	// bootstrapAdminToken is the one-time secret that makes its presenter
+	// the first admin.
+	bootstrapAdminToken string
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -36,6 +55,11 @@ func (cfg *apiConfig) middlewareMetricsDsp(w http.ResponseWriter, req *http.Requ
 }
 
 func (cfg *apiConfig) middlewareMetricsRst(w http.ResponseWriter, req *http.Request) {
+	principal, _ := auth.PrincipalFromContext(req.Context())
+	cfg.recordAudit(req, auditEvent{
+		Action:  "admin.metrics_reset",
+		ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+	})
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	cfg.fileserverHits.Store(0)
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+const authRealm = "chirpy"
+
+// middlewareRequireAuth lets the request through only with a valid access
+// token carrying every one of scopes, and stores the caller in the request
+// context for auth.PrincipalFromContext.
+func (cfg *apiConfig) middlewareRequireAuth(next http.HandlerFunc, scopes ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			respondUnauthorized(w, "", "no auth token in request", nil)
+			return
+		}
+		principal, ok := cfg.authenticate(w, r)
+		if !ok {
+			return
+		}
+		for _, scope := range scopes {
+			if !principal.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q, error="insufficient_scope", scope=%q`, authRealm, scope))
+				respondWithError(w, http.StatusForbidden, "token lacks the "+scope+" scope", nil)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// middlewareRequireRole is middlewareRequireAuth for callers whose role is
+// at least role. The role is read from the database on every request, so
+// a demotion takes effect before the user's tokens expire.
+func (cfg *apiConfig) middlewareRequireRole(next http.HandlerFunc, role string, scopes ...string) http.Handler {
+	return cfg.middlewareRequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := auth.PrincipalFromContext(r.Context())
+		if !auth.RoleAtLeast(principal.Role, role) {
+			respondWithError(w, http.StatusForbidden, "requires the "+role+" role", nil)
+			return
+		}
+		next(w, r)
+	}, scopes...)
+}
+
+// middlewareAdmin guards the /admin endpoints.
+func (cfg *apiConfig) middlewareAdmin(next http.HandlerFunc) http.Handler {
+	return cfg.middlewareRequireRole(next, auth.RoleAdmin, auth.ScopeAdmin)
+}
+
+// middlewareOptionalAuth stores the caller in the request context when a
+// valid access token is presented. Anyone else, including callers with an
+// expired or otherwise invalid token, gets through anonymously.
+func (cfg *apiConfig) middlewareOptionalAuth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, err := cfg.resolvePrincipal(r)
+		var authErr *authError
+		if errors.As(err, &authErr) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check token.", err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// authError is why the credentials of a request were refused. code is the
+// RFC 6750 error code of the challenge, empty when none applies.
+type authError struct {
+	code string
+	msg  string
+	err  error
+}
+
+func (e *authError) Error() string {
+	return e.msg
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+// authenticate resolves the request's bearer token into a principal. On
+// failure it has already written the response.
+func (cfg *apiConfig) authenticate(w http.ResponseWriter, r *http.Request) (auth.Principal, bool) {
+	principal, err := cfg.resolvePrincipal(r)
+	var authErr *authError
+	if errors.As(err, &authErr) {
+		respondUnauthorized(w, authErr.code, authErr.msg, authErr.err)
+		return auth.Principal{}, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check token.", err)
+		return auth.Principal{}, false
+	}
+	return principal, true
+}
+
+// resolvePrincipal resolves the request's bearer token, a JWT or a
+// personal access token, into a principal. Refused credentials are
+// reported as an *authError; any other error is the server's.
+func (cfg *apiConfig) resolvePrincipal(r *http.Request) (auth.Principal, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if errors.Is(err, auth.ErrWrongAuthScheme) {
+		return auth.Principal{}, &authError{msg: "bearer token required", err: err}
+	}
+	if err != nil {
+		return auth.Principal{}, &authError{code: "invalid_request", msg: "malformed auth token in request", err: err}
+	}
+
+	if auth.IsPersonalAccessToken(token) {
+		return cfg.resolvePersonalToken(r, token)
+	}
+
+	claims, err := cfg.validateAccessToken(r.Context(), token)
+	if errors.Is(err, errInvalidToken) {
+		return auth.Principal{}, &authError{code: "invalid_token", msg: "invalid token in request", err: err}
+	}
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	userDB, err := cfg.activeUser(r.Context(), claims.UserID)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	isRed, err := cfg.isChirpyRed(r.Context(), userDB.ID)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	return auth.Principal{
+		UserID:      claims.UserID,
+		Scopes:      claims.Scopes,
+		Role:        userDB.Role,
+		IsChirpyRed: isRed,
+		SessionID:   claims.SessionID,
+		TokenID:     claims.TokenID,
+		ExpiresAt:   claims.ExpiresAt,
+	}, nil
+}
+
+// activeUser returns the user a token was issued to, refusing the token
+// if the user is gone or suspended.
+func (cfg *apiConfig) activeUser(ctx context.Context, userID uuid.UUID) (database.User, error) {
+	userDB, err := cfg.db.GetUserByID(ctx, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, &authError{code: "invalid_token", msg: "invalid token in request", err: err}
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+	if userDB.SuspendedAt.Valid {
+		return database.User{}, &authError{code: "invalid_token", msg: "account suspended"}
+	}
+	return userDB, nil
+}
+
+// respondUnauthorized sends a 401 with the RFC 6750 challenge. errCode is
+// left out when the request carried no credentials at all.
+func respondUnauthorized(w http.ResponseWriter, errCode, msg string, err error) {
+	challenge := fmt.Sprintf("Bearer realm=%q", authRealm)
+	if errCode != "" {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", errCode, msg)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	respondWithError(w, http.StatusUnauthorized, msg, err)
+}
+
+func (cfg *apiConfig) resolvePersonalToken(r *http.Request, token string) (auth.Principal, error) {
+	tokenDB, err := cfg.db.GetPersonalAccessTokenByHash(r.Context(), auth.HashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return auth.Principal{}, &authError{code: "invalid_token", msg: "invalid token in request", err: err}
+	}
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	if tokenDB.RevokedAt.Valid || tokenDB.ExpiresAt.Valid && time.Now().After(tokenDB.ExpiresAt.Time) {
+		return auth.Principal{}, &authError{code: "invalid_token", msg: "invalid token in request"}
+	}
+	// Logging out everywhere and changing the password end personal
+	// access tokens too.
+	cutoff, err := cfg.denylist.UserCutoff(r.Context(), tokenDB.UserID)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	if tokenDB.CreatedAt.Before(cutoff) {
+		return auth.Principal{}, &authError{code: "invalid_token", msg: "invalid token in request", err: errTokenRevoked}
+	}
+
+	userDB, err := cfg.activeUser(r.Context(), tokenDB.UserID)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	isRed, err := cfg.isChirpyRed(r.Context(), userDB.ID)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+
+	err = cfg.db.TouchPersonalAccessToken(r.Context(), tokenDB.ID)
+	if err != nil {
+		log.Printf("Error recording token use: %s", err)
+	}
+
+	return auth.Principal{
+		UserID:        tokenDB.UserID,
+		Scopes:        tokenDB.Scopes,
+		Role:          userDB.Role,
+		IsChirpyRed:   isRed,
+		TokenID:       tokenDB.ID.String(),
+		ExpiresAt:     tokenDB.ExpiresAt.Time,
+		PersonalToken: true,
+	}, nil
+}
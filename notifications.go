@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/outbox"
+)
+
+// Types of notification. Each can be muted separately.
+const (
+	notificationMention   = "mention"
+	notificationReply     = "reply"
+	notificationLike      = "like"
+	notificationFollow    = "follow"
+	notificationChirpyRed = "chirpy_red"
+)
+
+var notificationTypes = []string{
+	notificationMention,
+	notificationReply,
+	notificationLike,
+	notificationFollow,
+	notificationChirpyRed,
+}
+
+const (
+	// maxMentionsPerChirp bounds how many users one chirp can notify.
+	maxMentionsPerChirp = 10
+	// notificationActorsShown is how many of the latest actors of an
+	// aggregated notification are listed.
+	notificationActorsShown = 3
+)
+
+type Notification struct {
+	ID        uuid.UUID   `json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	Type      string      `json:"type"`
+	Message   string      `json:"message"`
+	ActorIDs  []uuid.UUID `json:"actor_ids"`
+	// ActorCount counts every actor folded into the notification, of
+	// whom ActorIDs are the latest.
+	ActorCount int        `json:"actor_count"`
+	SubjectID  *uuid.UUID `json:"subject_id"`
+	ReadAt     *time.Time `json:"read_at"`
+}
+
+func notificationDBToJSON(notificationDB database.Notification) Notification {
+	actors := notificationDB.ActorIds
+	notification := Notification{
+		ID:         notificationDB.ID,
+		CreatedAt:  notificationDB.CreatedAt,
+		UpdatedAt:  notificationDB.UpdatedAt,
+		Type:       notificationDB.Type,
+		Message:    notificationMessage(notificationDB.Type, len(actors)),
+		ActorIDs:   actors[:min(len(actors), notificationActorsShown)],
+		ActorCount: len(actors),
+	}
+	if notificationDB.SubjectID.Valid {
+		notification.SubjectID = &notificationDB.SubjectID.UUID
+	}
+	if notificationDB.ReadAt.Valid {
+		notification.ReadAt = &notificationDB.ReadAt.Time
+	}
+	return notification
+}
+
+// notificationMessage says what happened without naming anyone, as users
+// are only known by their email address.
+func notificationMessage(notificationType string, actors int) string {
+	var action string
+	switch notificationType {
+	case notificationMention:
+		action = "mentioned you"
+	case notificationReply:
+		action = "replied to your chirp"
+	case notificationLike:
+		action = "liked your chirp"
+	case notificationFollow:
+		action = "followed you"
+	case notificationChirpyRed:
+		return "Welcome to Chirpy Red!"
+	default:
+		return ""
+	}
+	switch {
+	case actors <= 1:
+		return "Someone " + action
+	case actors == 2:
+		return "Someone and 1 other " + action
+	}
+	return fmt.Sprintf("Someone and %d others %s", actors-1, action)
+}
+
+// createNotifications is the outbox subscriber that notifies users of
+// what other users did. Notifying twice about the same event only folds
+// the actor into the notification again.
+func (cfg *apiConfig) createNotifications(ctx context.Context, ev outbox.Event) error {
+	switch ev.Type {
+	case eventChirpCreated:
+		return cfg.notifyChirpCreated(ctx, ev)
+	case eventChirpLiked:
+		return cfg.notifyLike(ctx, ev)
+	case eventUserFollowed:
+		return cfg.notifyFollow(ctx, ev)
+	case eventUserUpgraded:
+		// Every upgrade gets its own notification.
+		_, err := cfg.db.AddNotification(ctx, database.AddNotificationParams{
+			UserID:   ev.UserID,
+			Type:     notificationChirpyRed,
+			GroupKey: notificationChirpyRed + ":" + ev.ID.String(),
+		})
+		return err
+	}
+	return nil
+}
+
+// notifyChirpCreated notifies the author of the chirp a new chirp replies
+// to and the users it mentions, once it is published.
+func (cfg *apiConfig) notifyChirpCreated(ctx context.Context, ev outbox.Event) error {
+	chirp := Chirp{}
+	err := json.Unmarshal(ev.Payload, &chirp)
+	if err != nil {
+		return err
+	}
+	if chirp.PublishedAt.After(time.Now()) {
+		return &outbox.DeferError{Until: chirp.PublishedAt}
+	}
+
+	// The chirp may have been edited or deleted while it was scheduled.
+	chirpDB, err := cfg.db.GetOneChirp(ctx, chirp.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = cfg.notifyReply(ctx, chirpDB)
+	if err != nil {
+		return err
+	}
+	return cfg.notifyMentions(ctx, chirpDB)
+}
+
+// notifyReply notifies the author of the chirp chirpDB replies to. Replies
+// to the same chirp are folded into one notification.
+func (cfg *apiConfig) notifyReply(ctx context.Context, chirpDB database.Chirp) error {
+	if !chirpDB.ReplyToID.Valid {
+		return nil
+	}
+	parentDB, err := cfg.db.GetOneChirp(ctx, chirpDB.ReplyToID.UUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if parentDB.UserID == chirpDB.UserID {
+		return nil
+	}
+	_, err = cfg.db.AddNotification(ctx, database.AddNotificationParams{
+		UserID:    parentDB.UserID,
+		Type:      notificationReply,
+		GroupKey:  notificationReply + ":" + parentDB.ID.String(),
+		ActorID:   uuid.NullUUID{UUID: chirpDB.UserID, Valid: true},
+		SubjectID: uuid.NullUUID{UUID: parentDB.ID, Valid: true},
+	})
+	return err
+}
+
+// notifyMentions notifies the users chirpDB mentions. Each chirp gets its
+// own notification, so that one about a mention can't be folded into
+// another and lead to the wrong chirp.
+func (cfg *apiConfig) notifyMentions(ctx context.Context, chirpDB database.Chirp) error {
+	emails := mentionedEmails(chirpDB.Body)
+	for _, email := range emails[:min(len(emails), maxMentionsPerChirp)] {
+		userDB, err := cfg.db.GetUserByEmail(ctx, email)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if userDB.ID == chirpDB.UserID {
+			continue
+		}
+		_, err = cfg.db.AddNotification(ctx, database.AddNotificationParams{
+			UserID:    userDB.ID,
+			Type:      notificationMention,
+			GroupKey:  notificationMention + ":" + chirpDB.ID.String(),
+			ActorID:   uuid.NullUUID{UUID: chirpDB.UserID, Valid: true},
+			SubjectID: uuid.NullUUID{UUID: chirpDB.ID, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyLike notifies the author of a liked chirp. Likes of the same chirp
+// are folded into one notification.
+func (cfg *apiConfig) notifyLike(ctx context.Context, ev outbox.Event) error {
+	like := struct {
+		ChirpID  uuid.UUID `json:"chirp_id"`
+		UserID   uuid.UUID `json:"user_id"`
+		AuthorID uuid.UUID `json:"author_id"`
+	}{}
+	err := json.Unmarshal(ev.Payload, &like)
+	if err != nil {
+		return err
+	}
+	if like.UserID == like.AuthorID {
+		return nil
+	}
+	_, err = cfg.db.AddNotification(ctx, database.AddNotificationParams{
+		UserID:    like.AuthorID,
+		Type:      notificationLike,
+		GroupKey:  notificationLike + ":" + like.ChirpID.String(),
+		ActorID:   uuid.NullUUID{UUID: like.UserID, Valid: true},
+		SubjectID: uuid.NullUUID{UUID: like.ChirpID, Valid: true},
+	})
+	return err
+}
+
+// notifyFollow notifies a user of a new follower. New followers are
+// folded into one notification until it is read.
+func (cfg *apiConfig) notifyFollow(ctx context.Context, ev outbox.Event) error {
+	follow := struct {
+		FollowerID uuid.UUID `json:"follower_id"`
+		FollowedID uuid.UUID `json:"followed_id"`
+	}{}
+	err := json.Unmarshal(ev.Payload, &follow)
+	if err != nil {
+		return err
+	}
+	_, err = cfg.db.AddNotification(ctx, database.AddNotificationParams{
+		UserID:   follow.FollowedID,
+		Type:     notificationFollow,
+		GroupKey: notificationFollow,
+		ActorID:  uuid.NullUUID{UUID: follow.FollowerID, Valid: true},
+	})
+	return err
+}
+
+// listNotifications lists the caller's notifications, most recently
+// updated first, or only the unread ones with unread=true.
+func (cfg *apiConfig) listNotifications(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	notificationsInDB, err := cfg.db.ListNotifications(r.Context(), database.ListNotificationsParams{
+		UserID:     principal.UserID,
+		UnreadOnly: r.URL.Query().Get("unread") == "true",
+		Limit:      limit,
+		Offset:     offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notifications, database error.", err)
+		return
+	}
+
+	unread, err := cfg.db.CountUnreadNotifications(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notifications, database error.", err)
+		return
+	}
+
+	type response struct {
+		UnreadCount   int64          `json:"unread_count"`
+		Notifications []Notification `json:"notifications"`
+	}
+	resp := response{
+		UnreadCount:   unread,
+		Notifications: make([]Notification, len(notificationsInDB)),
+	}
+	for i, notificationDB := range notificationsInDB {
+		resp.Notifications[i] = notificationDBToJSON(notificationDB)
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) markNotificationRead(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	notificationID, err := uuid.Parse(r.PathValue("notificationID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't mark notification read, wrong UUID.", err)
+		return
+	}
+
+	marked, err := cfg.db.MarkNotificationRead(r.Context(), database.MarkNotificationReadParams{
+		ID:     notificationID,
+		UserID: principal.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark notification read, database error.", err)
+		return
+	}
+	if marked == 0 {
+		respondWithError(w, http.StatusNotFound, "Notification doesn't exist.", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) markAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	err := cfg.db.MarkAllNotificationsRead(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't mark notifications read, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+type NotificationPreference struct {
+	Type  string `json:"type"`
+	Muted bool   `json:"muted"`
+}
+
+func (cfg *apiConfig) listNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	muted, err := cfg.db.ListMutedNotificationTypes(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get preferences, database error.", err)
+		return
+	}
+
+	preferences := make([]NotificationPreference, len(notificationTypes))
+	for i, notificationType := range notificationTypes {
+		preferences[i] = NotificationPreference{
+			Type:  notificationType,
+			Muted: slices.Contains(muted, notificationType),
+		}
+	}
+	respondWithJSON(w, http.StatusOK, preferences)
+}
+
+// setNotificationPreference mutes or unmutes one type of notification.
+// Muting stops new notifications; existing ones are kept.
+func (cfg *apiConfig) setNotificationPreference(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	notificationType := r.PathValue("type")
+	if !slices.Contains(notificationTypes, notificationType) {
+		respondWithError(w, http.StatusNotFound, "Unknown notification type.", nil)
+		return
+	}
+
+	type parameters struct {
+		Muted *bool `json:"muted"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil || params.Muted == nil {
+		respondWithError(w, http.StatusBadRequest, "Can't update preference, muted missing.", err)
+		return
+	}
+
+	if *params.Muted {
+		err = cfg.db.MuteNotificationType(r.Context(), database.MuteNotificationTypeParams{
+			UserID: principal.UserID,
+			Type:   notificationType,
+		})
+	} else {
+		err = cfg.db.UnmuteNotificationType(r.Context(), database.UnmuteNotificationTypeParams{
+			UserID: principal.UserID,
+			Type:   notificationType,
+		})
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update preference, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, NotificationPreference{Type: notificationType, Muted: *params.Muted})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/outbox"
+)
+
+func TestNotificationMessage(t *testing.T) {
+	tests := []struct {
+		notificationType string
+		actors           int
+		want             string
+	}{
+		{notificationMention, 1, "Someone mentioned you"},
+		{notificationReply, 1, "Someone replied to your chirp"},
+		{notificationReply, 3, "Someone and 2 others replied to your chirp"},
+		{notificationLike, 1, "Someone liked your chirp"},
+		{notificationLike, 2, "Someone and 1 other liked your chirp"},
+		{notificationMention, 2, "Someone and 1 other mentioned you"},
+		{notificationFollow, 1, "Someone followed you"},
+		{notificationFollow, 4, "Someone and 3 others followed you"},
+		{notificationChirpyRed, 0, "Welcome to Chirpy Red!"},
+	}
+
+	for _, tt := range tests {
+		if !slices.Contains(notificationTypes, tt.notificationType) {
+			t.Errorf("%q isn't a notification type", tt.notificationType)
+		}
+		if got := notificationMessage(tt.notificationType, tt.actors); got != tt.want {
+			t.Errorf("notificationMessage(%q, %d) = %q, want %q", tt.notificationType, tt.actors, got, tt.want)
+		}
+	}
+}
+
+func TestCreateNotifications(t *testing.T) {
+	cfg := testConfig(t)
+	ctx := context.Background()
+
+	newUser := func(t *testing.T) database.User {
+		t.Helper()
+		userDB, err := cfg.db.CreateUser(ctx, database.CreateUserParams{
+			Email:          "notified-" + uuid.NewString() + "@example.com",
+			HashedPassword: unusablePassword,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		deleteUserOnCleanup(t, cfg, userDB)
+		return userDB
+	}
+	followed := func(t *testing.T, followerID, followedID uuid.UUID) outbox.Event {
+		t.Helper()
+		payload, err := json.Marshal(map[string]any{"follower_id": followerID, "followed_id": followedID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return outbox.Event{ID: uuid.New(), Type: eventUserFollowed, UserID: followerID, Payload: payload}
+	}
+	dispatch := func(t *testing.T, ev outbox.Event) {
+		t.Helper()
+		err := cfg.createNotifications(ctx, ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// check compares userID's notifications, latest first, by their
+	// actors, and the unread count.
+	check := func(t *testing.T, userID uuid.UUID, wantActors [][]uuid.UUID, wantUnread int64) {
+		t.Helper()
+		notificationsInDB, err := cfg.db.ListNotifications(ctx, database.ListNotificationsParams{
+			UserID: userID,
+			Limit:  10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notificationsInDB) != len(wantActors) {
+			t.Fatalf("%d notifications, want %d", len(notificationsInDB), len(wantActors))
+		}
+		for i, notificationDB := range notificationsInDB {
+			if !slices.Equal(notificationDB.ActorIds, wantActors[i]) {
+				t.Errorf("notification %d actors = %v, want %v", i, notificationDB.ActorIds, wantActors[i])
+			}
+		}
+		unread, err := cfg.db.CountUnreadNotifications(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unread != wantUnread {
+			t.Errorf("CountUnreadNotifications() = %d, want %d", unread, wantUnread)
+		}
+	}
+
+	t.Run("same group is folded, latest actor first", func(t *testing.T) {
+		userDB := newUser(t)
+		first, second := uuid.New(), uuid.New()
+		dispatch(t, followed(t, first, userDB.ID))
+		dispatch(t, followed(t, second, userDB.ID))
+		dispatch(t, followed(t, first, userDB.ID))
+		check(t, userDB.ID, [][]uuid.UUID{{first, second}}, 1)
+	})
+
+	t.Run("dispatching an event again", func(t *testing.T) {
+		userDB := newUser(t)
+		ev := followed(t, uuid.New(), userDB.ID)
+		dispatch(t, ev)
+		dispatch(t, ev)
+		check(t, userDB.ID, [][]uuid.UUID{{ev.UserID}}, 1)
+	})
+
+	t.Run("new notification once read", func(t *testing.T) {
+		userDB := newUser(t)
+		first, second := uuid.New(), uuid.New()
+		dispatch(t, followed(t, first, userDB.ID))
+		err := cfg.db.MarkAllNotificationsRead(ctx, userDB.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dispatch(t, followed(t, second, userDB.ID))
+		check(t, userDB.ID, [][]uuid.UUID{{second}, {first}}, 1)
+	})
+
+	t.Run("groups are counted separately", func(t *testing.T) {
+		userDB := newUser(t)
+		first, second := uuid.New(), uuid.New()
+		for _, actorID := range []uuid.UUID{first, second} {
+			chirpID := uuid.New()
+			_, err := cfg.db.AddNotification(ctx, database.AddNotificationParams{
+				UserID:    userDB.ID,
+				Type:      notificationLike,
+				GroupKey:  notificationLike + ":" + chirpID.String(),
+				ActorID:   uuid.NullUUID{UUID: actorID, Valid: true},
+				SubjectID: uuid.NullUUID{UUID: chirpID, Valid: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		check(t, userDB.ID, [][]uuid.UUID{{second}, {first}}, 2)
+	})
+
+	t.Run("muted type", func(t *testing.T) {
+		userDB := newUser(t)
+		err := cfg.db.MuteNotificationType(ctx, database.MuteNotificationTypeParams{
+			UserID: userDB.ID,
+			Type:   notificationFollow,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dispatch(t, followed(t, uuid.New(), userDB.ID))
+		check(t, userDB.ID, nil, 0)
+
+		// Other types still get through.
+		actorID := uuid.New()
+		added, err := cfg.db.AddNotification(ctx, database.AddNotificationParams{
+			UserID:   userDB.ID,
+			Type:     notificationLike,
+			GroupKey: notificationLike + ":" + uuid.NewString(),
+			ActorID:  uuid.NullUUID{UUID: actorID, Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != 1 {
+			t.Errorf("AddNotification() = %d, want 1", added)
+		}
+		check(t, userDB.ID, [][]uuid.UUID{{actorID}}, 1)
+	})
+}
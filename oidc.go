@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/oidc"
+)
+
+const (
+	oidcStateLifetime = 10 * time.Minute
+	oidcStateCookie   = "chirpy_oidc_state"
+	// unusablePassword is stored for users created through an identity
+	// provider. It isn't a bcrypt hash, so password login always fails.
+	unusablePassword = "unset"
+)
+
+// loadOIDCProviders discovers every provider named in OIDC_PROVIDERS. For
+// a provider called google it reads OIDC_GOOGLE_ISSUER, _CLIENT_ID,
+// _CLIENT_SECRET, _REDIRECT_URL and optionally _SCOPES. A provider that
+// can't be discovered is logged and left out.
+func loadOIDCProviders(ctx context.Context) map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		cfg := oidc.Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+		provider, err := oidc.Discover(ctx, cfg, nil)
+		if err != nil {
+			log.Printf("Error loading identity provider: %s", err)
+			continue
+		}
+		providers[name] = provider
+	}
+	return providers
+}
+
+// oidcLogin starts the authorization code flow. The state is kept both in
+// the database, with the nonce and PKCE verifier, and in a cookie, so that
+// the callback can only be completed by the browser that started it.
+func (cfg *apiConfig) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider.", nil)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Can't start login.", err)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	err := cfg.db.DeleteExpiredOIDCLoginStates(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't start login, database error.", err)
+		return
+	}
+	err = cfg.db.CreateOIDCLoginState(r.Context(), database.CreateOIDCLoginStateParams{
+		State:        state,
+		ExpiresAt:    time.Now().Add(oidcStateLifetime),
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't start login, database error.", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/",
+		MaxAge:   int(oidcStateLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// oidcCallback finishes the flow and logs the user in exactly like
+// loginUser does, linking or creating the account on first use.
+func (cfg *apiConfig) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Unknown identity provider.", nil)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "Identity provider refused login: "+errCode, nil)
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		respondWithError(w, http.StatusBadRequest, "Login state doesn't match.", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   oidcStateCookie,
+		Path:   "/api/auth/",
+		MaxAge: -1,
+	})
+
+	stateDB, err := cfg.db.ConsumeOIDCLoginState(r.Context(), state)
+	if err != nil || stateDB.Provider != provider.Name() {
+		respondWithError(w, http.StatusBadRequest, "Login expired, try again.", err)
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), query.Get("code"), stateDB.CodeVerifier, stateDB.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't verify identity.", err)
+		return
+	}
+
+	userDB, err := cfg.userForIdentity(r, provider.Name(), identity)
+	if errors.Is(err, errUnverifiedEmail) {
+		respondWithError(w, http.StatusForbidden, "Identity provider hasn't verified this email address.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log in, database error.", err)
+		return
+	}
+	cfg.finishLogin(w, r, userDB)
+}
+
+var errUnverifiedEmail = errors.New("email address not verified by identity provider")
+
+// userForIdentity finds the user an external identity belongs to. Unknown
+// identities are linked to the user with the same email address, or to a
+// new user, but only if the provider verified that address.
+func (cfg *apiConfig) userForIdentity(r *http.Request, provider string, identity oidc.Identity) (database.User, error) {
+	ctx := r.Context()
+	identityDB, err := cfg.db.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  identity.Subject,
+	})
+	if err == nil {
+		return cfg.db.GetUserByID(ctx, identityDB.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+	if !identity.EmailVerified || identity.Email == "" {
+		return database.User{}, errUnverifiedEmail
+	}
+
+	tx, err := cfg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// Email addresses are matched whatever their case, so that an
+	// identity doesn't end up with a second account.
+	userDB, err := qtx.GetUserByEmail(ctx, identity.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		userDB, err = qtx.CreateUser(ctx, database.CreateUserParams{
+			Email:          identity.Email,
+			HashedPassword: unusablePassword,
+		})
+		if err == nil {
+			err = publishUserCreatedTx(ctx, qtx, userDB)
+		}
+	}
+	if err != nil {
+		return database.User{}, err
+	}
+
+	_, err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		UserID:   userDB.ID,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:   "identity.linked",
+		ActorID:  uuid.NullUUID{UUID: userDB.ID, Valid: true},
+		Target:   provider + ":" + identity.Subject,
+		Metadata: map[string]string{"email": identity.Email},
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return userDB, nil
+}
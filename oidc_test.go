@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/oidc"
+	"github.com/romusking/chirpy/internal/oidc/oidctest"
+)
+
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	server, err := oidctest.NewServer("chirpy", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Name:     "stub",
+		Issuer:   server.URL,
+		ClientID: "chirpy",
+	}, server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{oidcProviders: map[string]*oidc.Provider{"stub": provider}}
+
+	tests := []struct {
+		name     string
+		provider string
+		query    string
+		cookie   string
+		want     int
+	}{
+		{name: "unknown provider", provider: "other", query: "state=a&code=c", cookie: "a", want: http.StatusNotFound},
+		{name: "no cookie", provider: "stub", query: "state=a&code=c", want: http.StatusBadRequest},
+		{name: "state mismatch", provider: "stub", query: "state=a&code=c", cookie: "b", want: http.StatusBadRequest},
+		{name: "no state", provider: "stub", query: "code=c", cookie: "a", want: http.StatusBadRequest},
+		{name: "provider error", provider: "stub", query: "error=access_denied", cookie: "a", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/auth/"+tt.provider+"/callback?"+tt.query, nil)
+			r.SetPathValue("provider", tt.provider)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			cfg.oidcCallback(w, r)
+			if w.Code != tt.want {
+				t.Errorf("oidcCallback() status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestUserForIdentity(t *testing.T) {
+	cfg := testConfig(t)
+	ctx := context.Background()
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/stub/callback", nil)
+
+	email := "Linked-" + uuid.NewString() + "@Example.com"
+	existing, err := cfg.db.CreateUser(ctx, database.CreateUserParams{
+		Email:          email,
+		HashedPassword: unusablePassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteUserOnCleanup(t, cfg, existing)
+
+	t.Run("unverified email", func(t *testing.T) {
+		_, err := cfg.userForIdentity(r, "stub", oidc.Identity{Subject: uuid.NewString(), Email: email})
+		if !errors.Is(err, errUnverifiedEmail) {
+			t.Errorf("userForIdentity() error = %v, want %v", err, errUnverifiedEmail)
+		}
+	})
+
+	subject := uuid.NewString()
+	t.Run("links by email whatever its case", func(t *testing.T) {
+		userDB, err := cfg.userForIdentity(r, "stub", oidc.Identity{
+			Subject:       subject,
+			Email:         strings.ToLower(email),
+			EmailVerified: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userDB.ID != existing.ID {
+			t.Errorf("userForIdentity() linked user %v, want existing user %v", userDB.ID, existing.ID)
+		}
+	})
+
+	t.Run("known identity", func(t *testing.T) {
+		// The provider's email no longer matters once linked.
+		userDB, err := cfg.userForIdentity(r, "stub", oidc.Identity{
+			Subject: subject,
+			Email:   "changed-" + uuid.NewString() + "@example.com",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userDB.ID != existing.ID {
+			t.Errorf("userForIdentity() = user %v, want %v", userDB.ID, existing.ID)
+		}
+	})
+
+	t.Run("new user", func(t *testing.T) {
+		newEmail := "new-" + uuid.NewString() + "@example.com"
+		userDB, err := cfg.userForIdentity(r, "stub", oidc.Identity{
+			Subject:       uuid.NewString(),
+			Email:         newEmail,
+			EmailVerified: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		deleteUserOnCleanup(t, cfg, userDB)
+		if userDB.ID == existing.ID || userDB.Email != newEmail {
+			t.Errorf("userForIdentity() = %v %s, want a new user for %s", userDB.ID, userDB.Email, newEmail)
+		}
+
+		var n int
+		err = cfg.conn.QueryRow("SELECT COUNT(*) FROM domain_events WHERE type = $1 AND user_id = $2", eventUserCreated, userDB.ID).Scan(&n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("%d %s events for the new user, want 1", n, eventUserCreated)
+		}
+	})
+}
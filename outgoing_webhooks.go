@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/outbox"
+	"github.com/romusking/chirpy/internal/webhook"
+)
+
+// Domain events that can be sent to webhook subscriptions.
+var webhookEventTypes = []string{
+	eventChirpCreated,
+	eventChirpDeleted,
+	eventUserCreated,
+	eventUserUpgraded,
+}
+
+// Statuses of an outgoing webhook delivery.
+const (
+	webhookDeliveryPending   = "pending"
+	webhookDeliverySucceeded = "succeeded"
+	webhookDeliveryDead      = "dead"
+)
+
+const (
+	webhookDeliveryInterval = 5 * time.Second
+	webhookDeliveryBatch    = 20
+	webhookDeliveryTimeout  = 10 * time.Second
+
+	// A delivery is retried with exponential backoff and moved to the
+	// dead state once it has failed maxWebhookAttempts times.
+	maxWebhookAttempts = 10
+	webhookRetryBase   = 30 * time.Second
+	webhookRetryMax    = 6 * time.Hour
+)
+
+type WebhookSubscription struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	AllUsers   bool      `json:"all_users"`
+	// Secret is only filled in when the subscription is created.
+	Secret string `json:"secret,omitempty"`
+}
+
+func webhookSubscriptionDBToJSON(subscriptionDB database.WebhookSubscription) WebhookSubscription {
+	return WebhookSubscription{
+		ID:         subscriptionDB.ID,
+		CreatedAt:  subscriptionDB.CreatedAt,
+		URL:        subscriptionDB.Url,
+		EventTypes: subscriptionDB.EventTypes,
+		AllUsers:   subscriptionDB.AllUsers,
+	}
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	EventType      string          `json:"event_type"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at"`
+	ResponseStatus *int32          `json:"response_status"`
+	LastError      string          `json:"last_error,omitempty"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+func webhookDeliveryDBToJSON(deliveryDB database.WebhookDelivery) WebhookDelivery {
+	delivery := WebhookDelivery{
+		ID:        deliveryDB.ID,
+		CreatedAt: deliveryDB.CreatedAt,
+		EventType: deliveryDB.EventType,
+		Status:    deliveryDB.Status,
+		Attempts:  deliveryDB.Attempts,
+		LastError: deliveryDB.LastError.String,
+		Payload:   deliveryDB.Payload,
+	}
+	if deliveryDB.Status == webhookDeliveryPending {
+		delivery.NextAttemptAt = &deliveryDB.NextAttemptAt
+	}
+	if deliveryDB.LastAttemptAt.Valid {
+		delivery.LastAttemptAt = &deliveryDB.LastAttemptAt.Time
+	}
+	if deliveryDB.ResponseStatus.Valid {
+		delivery.ResponseStatus = &deliveryDB.ResponseStatus.Int32
+	}
+	return delivery
+}
+
+// webhookPayload is the body sent to receivers. Its id is the domain
+// event's, shared by the deliveries of one event to different
+// subscriptions.
+type webhookPayload struct {
+	ID        uuid.UUID       `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// enqueueWebhookDeliveries is the outbox subscriber that queues an event
+// for every subscription that wants it and may see events about the
+// event's user. A delivery is only queued once per event and
+// subscription, however often the event is dispatched.
+func (cfg *apiConfig) enqueueWebhookDeliveries(ctx context.Context, ev outbox.Event) error {
+	data, err := cfg.webhookEventData(ctx, ev)
+	if err != nil || data == nil {
+		return err
+	}
+	payload, err := json.Marshal(webhookPayload{
+		ID:        ev.ID,
+		Type:      ev.Type,
+		CreatedAt: ev.OccurredAt,
+		Data:      data,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = cfg.db.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventType: ev.Type,
+		Payload:   payload,
+		EventID:   uuid.NullUUID{UUID: ev.ID, Valid: true},
+		UserID:    ev.UserID,
+	})
+	return err
+}
+
+// webhookEventData is the data sent for ev, or nil when nothing should be
+// sent. A scheduled chirp is announced once it is published, as it stands
+// then, and not at all if it was deleted before that; receivers don't hear
+// about the deletion either.
+func (cfg *apiConfig) webhookEventData(ctx context.Context, ev outbox.Event) (json.RawMessage, error) {
+	switch ev.Type {
+	case eventChirpCreated:
+		chirp := Chirp{}
+		err := json.Unmarshal(ev.Payload, &chirp)
+		if err != nil {
+			return nil, err
+		}
+		if !chirp.PublishedAt.After(ev.OccurredAt) {
+			return ev.Payload, nil
+		}
+		if chirp.PublishedAt.After(time.Now()) {
+			return nil, &outbox.DeferError{Until: chirp.PublishedAt}
+		}
+		chirpDB, err := cfg.db.GetOneChirp(ctx, chirp.ID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(chirpDBToChirpJSON(chirpDB))
+	case eventChirpDeleted:
+		deleted := struct {
+			PublishedAt time.Time `json:"published_at"`
+		}{}
+		err := json.Unmarshal(ev.Payload, &deleted)
+		if err != nil {
+			return nil, err
+		}
+		if deleted.PublishedAt.After(ev.OccurredAt) {
+			return nil, nil
+		}
+	}
+	return ev.Payload, nil
+}
+
+func makeWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// webhookHostAllowed refuses hosts that are plainly not on the public
+// internet. It is only a courtesy to callers: webhookClient checks the
+// address it connects to on every delivery, whatever a name resolves to.
+func webhookHostAllowed(host string) bool {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return webhook.IsPublicAddr(addr)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host != "localhost" && !strings.HasSuffix(host, ".localhost")
+}
+
+// createWebhookSubscription registers a URL for some event types. Events
+// are about the caller only, unless an admin asks for all_users.
+func (cfg *apiConfig) createWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	type parameters struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+		Secret     string   `json:"secret"`
+		AllUsers   bool     `json:"all_users"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't create webhook, invalid input.", err)
+		return
+	}
+
+	u, err := url.Parse(params.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		respondWithError(w, http.StatusBadRequest, "Webhook needs an http or https URL.", err)
+		return
+	}
+	isAdmin := principal.Role == auth.RoleAdmin && principal.HasScope(auth.ScopeAdmin)
+	// Deliveries carry user data, so only admins may send them in the
+	// clear, to receivers they run themselves.
+	if u.Scheme != "https" && !isAdmin {
+		respondWithError(w, http.StatusBadRequest, "Webhook needs an https URL.", nil)
+		return
+	}
+	if !webhookHostAllowed(u.Hostname()) {
+		respondWithError(w, http.StatusBadRequest, "Webhook URL must be on the public internet.", nil)
+		return
+	}
+	if len(params.EventTypes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Webhook needs at least one event type.", nil)
+		return
+	}
+	for _, eventType := range params.EventTypes {
+		if !slices.Contains(webhookEventTypes, eventType) {
+			respondWithError(w, http.StatusBadRequest, "Unknown event type "+eventType+".", nil)
+			return
+		}
+	}
+	if params.AllUsers && !isAdmin {
+		respondWithError(w, http.StatusForbidden, "Only admins can receive events about all users.", nil)
+		return
+	}
+	if params.Secret == "" {
+		params.Secret, err = makeWebhookSecret()
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Can't create webhook.", err)
+			return
+		}
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	subscriptionDB, err := qtx.CreateWebhookSubscription(r.Context(), database.CreateWebhookSubscriptionParams{
+		UserID:     principal.UserID,
+		Url:        params.URL,
+		EventTypes: params.EventTypes,
+		Secret:     params.Secret,
+		AllUsers:   params.AllUsers,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook, database error.", err)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:   "webhook.created",
+		ActorID:  uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:   subscriptionDB.ID.String(),
+		Metadata: map[string]string{"url": params.URL},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook, database error.", err)
+		return
+	}
+
+	resp := webhookSubscriptionDBToJSON(subscriptionDB)
+	resp.Secret = subscriptionDB.Secret
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (cfg *apiConfig) listWebhookSubscriptions(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	subscriptionsInDB, err := cfg.db.ListWebhookSubscriptions(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhooks, database error.", err)
+		return
+	}
+
+	subscriptions := make([]WebhookSubscription, len(subscriptionsInDB))
+	for i, subscriptionDB := range subscriptionsInDB {
+		subscriptions[i] = webhookSubscriptionDBToJSON(subscriptionDB)
+	}
+	respondWithJSON(w, http.StatusOK, subscriptions)
+}
+
+func (cfg *apiConfig) deleteWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	subscriptionID, err := uuid.Parse(r.PathValue("webhookID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't delete webhook, wrong UUID.", err)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	deleted, err := qtx.DeleteWebhookSubscription(r.Context(), database.DeleteWebhookSubscriptionParams{
+		ID:     subscriptionID,
+		UserID: principal.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook, database error.", err)
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, http.StatusNotFound, "Webhook doesn't exist.", nil)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:  "webhook.deleted",
+		ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:  subscriptionID.String(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// listWebhookDeliveries is the delivery log of one of the caller's
+// webhooks, newest first, optionally only those with the status query
+// parameter.
+func (cfg *apiConfig) listWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	subscriptionID, err := uuid.Parse(r.PathValue("webhookID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't get deliveries, wrong UUID.", err)
+		return
+	}
+
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	_, err = cfg.db.GetWebhookSubscription(r.Context(), database.GetWebhookSubscriptionParams{
+		ID:     subscriptionID,
+		UserID: principal.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Webhook doesn't exist.", err)
+		return
+	}
+
+	deliveriesInDB, err := cfg.db.ListWebhookDeliveries(r.Context(), database.ListWebhookDeliveriesParams{
+		SubscriptionID: subscriptionID,
+		Status:         nullString(r.URL.Query().Get("status")),
+		Limit:          limit,
+		Offset:         offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get deliveries, database error.", err)
+		return
+	}
+
+	deliveries := make([]WebhookDelivery, len(deliveriesInDB))
+	for i, deliveryDB := range deliveriesInDB {
+		deliveries[i] = webhookDeliveryDBToJSON(deliveryDB)
+	}
+	respondWithJSON(w, http.StatusOK, deliveries)
+}
+
+// retryWebhookDelivery puts a dead delivery back in the queue with a fresh
+// set of attempts, once the receiver has been fixed.
+func (cfg *apiConfig) retryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	subscriptionID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't retry delivery, wrong UUID.", err)
+		return
+	}
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't retry delivery, wrong UUID.", err)
+		return
+	}
+
+	_, err = cfg.db.GetWebhookSubscription(r.Context(), database.GetWebhookSubscriptionParams{
+		ID:     subscriptionID,
+		UserID: principal.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Webhook doesn't exist.", err)
+		return
+	}
+
+	deliveryDB, err := cfg.db.RetryWebhookDelivery(r.Context(), database.RetryWebhookDeliveryParams{
+		ID:             deliveryID,
+		SubscriptionID: subscriptionID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		respondWithError(w, http.StatusConflict, "Only dead deliveries can be retried.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retry delivery, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhookDeliveryDBToJSON(deliveryDB))
+}
+
+// deliverWebhooks sends due webhook deliveries every interval until ctx is
+// done. Several replicas may run it at once; a claimed delivery is leased
+// to one of them until it has been attempted.
+func (cfg *apiConfig) deliverWebhooks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := cfg.deliverDueWebhooks(ctx)
+		if err != nil {
+			log.Printf("Error delivering webhooks: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) deliverDueWebhooks(ctx context.Context) error {
+	for {
+		deliveries, err := cfg.db.ClaimWebhookDeliveries(ctx, database.ClaimWebhookDeliveriesParams{
+			// If this replica dies mid-attempt, another one picks the
+			// delivery up once the lease runs out.
+			LeaseUntil: time.Now().Add(2 * webhookDeliveryTimeout).UTC(),
+			Limit:      webhookDeliveryBatch,
+		})
+		if err != nil {
+			return err
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				cfg.deliverWebhook(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+
+		if len(deliveries) < webhookDeliveryBatch {
+			return nil
+		}
+	}
+}
+
+// deliverWebhook makes one attempt at a delivery and records the outcome.
+func (cfg *apiConfig) deliverWebhook(ctx context.Context, delivery database.ClaimWebhookDeliveriesRow) {
+	sendCtx, cancel := context.WithTimeout(ctx, webhookDeliveryTimeout)
+	defer cancel()
+
+	status, err := webhook.Send(sendCtx, cfg.webhookClient, delivery.Url, delivery.ID.String(), []byte(delivery.Secret), delivery.Payload)
+	responseStatus := sql.NullInt32{Int32: int32(status), Valid: status != 0}
+	if err == nil {
+		err = cfg.db.SucceedWebhookDelivery(ctx, database.SucceedWebhookDeliveryParams{
+			ID:             delivery.ID,
+			ResponseStatus: responseStatus,
+		})
+		if err != nil {
+			log.Printf("Error recording webhook delivery %s: %s", delivery.ID, err)
+		}
+		return
+	}
+
+	attempts := delivery.Attempts + 1
+	nextStatus := webhookDeliveryPending
+	if attempts >= maxWebhookAttempts {
+		nextStatus = webhookDeliveryDead
+	}
+	err = cfg.db.FailWebhookDelivery(ctx, database.FailWebhookDeliveryParams{
+		ID:             delivery.ID,
+		Status:         nextStatus,
+		NextAttemptAt:  time.Now().Add(webhookRetryDelay(attempts)).UTC(),
+		ResponseStatus: responseStatus,
+		LastError:      nullString(err.Error()),
+	})
+	if err != nil {
+		log.Printf("Error recording webhook delivery %s: %s", delivery.ID, err)
+	}
+}
+
+// webhookRetryDelay is how long to wait after the given number of failed
+// attempts: webhookRetryBase doubled each time, up to webhookRetryMax.
+func webhookRetryDelay(attempts int32) time.Duration {
+	delay := webhookRetryBase
+	for i := int32(1); i < attempts; i++ {
+		delay *= 2
+		if delay >= webhookRetryMax {
+			return webhookRetryMax
+		}
+	}
+	return delay
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/outbox"
+	"github.com/romusking/chirpy/internal/webhook"
+)
+
+func TestWebhookRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int32
+		want     time.Duration
+	}{
+		{attempts: 1, want: 30 * time.Second},
+		{attempts: 2, want: time.Minute},
+		{attempts: 3, want: 2 * time.Minute},
+		{attempts: 5, want: 8 * time.Minute},
+		{attempts: 9, want: 128 * time.Minute},
+		{attempts: 10, want: 256 * time.Minute},
+		{attempts: 11, want: webhookRetryMax},
+		{attempts: 100, want: webhookRetryMax},
+	}
+
+	for _, tt := range tests {
+		if got := webhookRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("webhookRetryDelay(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookEventData(t *testing.T) {
+	cfg := &apiConfig{}
+	now := time.Now().UTC()
+	later := now.Add(time.Hour)
+	payload := func(v any) json.RawMessage {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name      string
+		ev        outbox.Event
+		wantDefer bool
+		wantSent  bool
+	}{
+		{
+			name:     "chirp published straight away",
+			ev:       outbox.Event{Type: eventChirpCreated, OccurredAt: now, Payload: payload(Chirp{ID: uuid.New(), PublishedAt: now})},
+			wantSent: true,
+		},
+		{
+			name:      "scheduled chirp",
+			ev:        outbox.Event{Type: eventChirpCreated, OccurredAt: now, Payload: payload(Chirp{ID: uuid.New(), PublishedAt: later})},
+			wantDefer: true,
+		},
+		{
+			name:     "published chirp deleted",
+			ev:       outbox.Event{Type: eventChirpDeleted, OccurredAt: now, Payload: payload(map[string]any{"id": uuid.New(), "published_at": now.Add(-time.Hour)})},
+			wantSent: true,
+		},
+		{
+			name: "scheduled chirp deleted",
+			ev:   outbox.Event{Type: eventChirpDeleted, OccurredAt: now, Payload: payload(map[string]any{"id": uuid.New(), "published_at": later})},
+		},
+		{
+			name:     "user created",
+			ev:       outbox.Event{Type: eventUserCreated, OccurredAt: now, Payload: payload(map[string]any{"id": uuid.New()})},
+			wantSent: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := cfg.webhookEventData(context.Background(), tt.ev)
+			var deferErr *outbox.DeferError
+			if tt.wantDefer {
+				if !errors.As(err, &deferErr) || !deferErr.Until.Equal(later) {
+					t.Errorf("webhookEventData() error = %v, want deferral until %v", err, later)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sent := data != nil; sent != tt.wantSent {
+				t.Errorf("webhookEventData() sends %v, want %v", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+// webhookFixture is a user with a webhook subscription to a receiver that
+// answers with status, and one delivery queued for it.
+type webhookFixture struct {
+	cfg          *apiConfig
+	user         database.User
+	subscription database.WebhookSubscription
+	received     chan http.Header
+}
+
+func newWebhookFixture(t *testing.T, status int) *webhookFixture {
+	t.Helper()
+	cfg := testConfig(t)
+	ctx := context.Background()
+
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	// Test receivers are on loopback, which webhook.NewClient refuses.
+	cfg.webhookClient = server.Client()
+
+	userDB, err := cfg.db.CreateUser(ctx, database.CreateUserParams{
+		Email:          "webhooks-" + uuid.NewString() + "@example.com",
+		HashedPassword: unusablePassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteUserOnCleanup(t, cfg, userDB)
+
+	subscriptionDB, err := cfg.db.CreateWebhookSubscription(ctx, database.CreateWebhookSubscriptionParams{
+		UserID:     userDB.ID,
+		Url:        server.URL,
+		EventTypes: []string{eventChirpCreated},
+		Secret:     "whsec_test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, _ := json.Marshal(map[string]string{"id": uuid.NewString()})
+	_, err = cfg.db.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventType: eventChirpCreated,
+		Payload:   payload,
+		EventID:   uuid.NullUUID{UUID: uuid.New(), Valid: true},
+		UserID:    userDB.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &webhookFixture{
+		cfg:          cfg,
+		user:         userDB,
+		subscription: subscriptionDB,
+		received:     received,
+	}
+}
+
+func (f *webhookFixture) delivery(t *testing.T) database.WebhookDelivery {
+	t.Helper()
+	deliveries, err := f.cfg.db.ListWebhookDeliveries(context.Background(), database.ListWebhookDeliveriesParams{
+		SubscriptionID: f.subscription.ID,
+		Limit:          10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("subscription has %d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+// attempt makes the next attempt at the fixture's delivery as if it had
+// already failed attempts times.
+func (f *webhookFixture) attempt(t *testing.T, attempts int32) database.WebhookDelivery {
+	t.Helper()
+	delivery := f.delivery(t)
+	_, err := f.cfg.conn.Exec("UPDATE webhook_deliveries SET attempts = $2 WHERE id = $1", delivery.ID, attempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.cfg.deliverWebhook(context.Background(), database.ClaimWebhookDeliveriesRow{
+		ID:        delivery.ID,
+		EventType: delivery.EventType,
+		Payload:   delivery.Payload,
+		Attempts:  attempts,
+		Url:       f.subscription.Url,
+		Secret:    f.subscription.Secret,
+	})
+	select {
+	case h := <-f.received:
+		verifier := webhook.NewVerifier([][]byte{[]byte(f.subscription.Secret)}, time.Minute)
+		if err := verifier.Verify(h, delivery.Payload); err != nil {
+			t.Errorf("delivery isn't signed with the subscription's secret: %v", err)
+		}
+		if h.Get(webhook.IDHeader) != delivery.ID.String() {
+			t.Errorf("%s = %q, want the delivery id %s", webhook.IDHeader, h.Get(webhook.IDHeader), delivery.ID)
+		}
+	default:
+		t.Fatal("receiver wasn't called")
+	}
+	return f.delivery(t)
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		f := newWebhookFixture(t, http.StatusOK)
+		delivery := f.attempt(t, 0)
+		if delivery.Status != webhookDeliverySucceeded || delivery.Attempts != 1 {
+			t.Errorf("delivery = %s after %d attempts, want %s after 1", delivery.Status, delivery.Attempts, webhookDeliverySucceeded)
+		}
+		if delivery.ResponseStatus != (sql.NullInt32{Int32: http.StatusOK, Valid: true}) {
+			t.Errorf("response status = %v, want %d", delivery.ResponseStatus, http.StatusOK)
+		}
+	})
+
+	t.Run("failure is retried with backoff", func(t *testing.T) {
+		f := newWebhookFixture(t, http.StatusServiceUnavailable)
+		before := time.Now()
+		delivery := f.attempt(t, 2)
+		if delivery.Status != webhookDeliveryPending || delivery.Attempts != 3 {
+			t.Fatalf("delivery = %s after %d attempts, want %s after 3", delivery.Status, delivery.Attempts, webhookDeliveryPending)
+		}
+		wait := delivery.NextAttemptAt.Sub(before.UTC())
+		if want := webhookRetryDelay(3); wait < want || wait > want+time.Minute {
+			t.Errorf("next attempt in %v, want %v", wait, want)
+		}
+		if !delivery.LastError.Valid {
+			t.Error("failed delivery has no error")
+		}
+	})
+
+	t.Run("dead after the last attempt", func(t *testing.T) {
+		f := newWebhookFixture(t, http.StatusServiceUnavailable)
+		delivery := f.attempt(t, maxWebhookAttempts-1)
+		if delivery.Status != webhookDeliveryDead || delivery.Attempts != maxWebhookAttempts {
+			t.Errorf("delivery = %s after %d attempts, want %s after %d", delivery.Status, delivery.Attempts, webhookDeliveryDead, maxWebhookAttempts)
+		}
+	})
+}
+
+func TestRetryWebhookDelivery(t *testing.T) {
+	f := newWebhookFixture(t, http.StatusServiceUnavailable)
+	dead := f.attempt(t, maxWebhookAttempts-1)
+
+	retry := func(userID uuid.UUID) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/webhooks/x/deliveries/y/retry", nil)
+		r.SetPathValue("webhookID", f.subscription.ID.String())
+		r.SetPathValue("deliveryID", dead.ID.String())
+		r = r.WithContext(auth.WithPrincipal(r.Context(), auth.Principal{UserID: userID}))
+		w := httptest.NewRecorder()
+		f.cfg.retryWebhookDelivery(w, r)
+		return w
+	}
+
+	if w := retry(uuid.New()); w.Code != http.StatusNotFound {
+		t.Errorf("retry by another user = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	w := retry(f.user.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("retry = %d %s, want %d", w.Code, w.Body, http.StatusOK)
+	}
+	delivery := f.delivery(t)
+	if delivery.Status != webhookDeliveryPending || delivery.Attempts != 0 || delivery.NextAttemptAt.After(time.Now().UTC()) {
+		t.Errorf("retried delivery = %s after %d attempts, next at %v; want %s, 0, due now", delivery.Status, delivery.Attempts, delivery.NextAttemptAt, webhookDeliveryPending)
+	}
+
+	if w := retry(f.user.ID); w.Code != http.StatusConflict {
+		t.Errorf("retry of a pending delivery = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	// Token is only filled in when the token is created.
+	Token string `json:"token,omitempty"`
+}
+
+func (cfg *apiConfig) createPersonalToken(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	// Otherwise a short-lived or leaked token could replace itself with
+	// one that never expires.
+	if principal.PersonalToken {
+		respondWithError(w, http.StatusForbidden, "Log in to create tokens; personal access tokens can't.", nil)
+		return
+	}
+
+	type parameters struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't create token, invalid input.", err)
+		return
+	}
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Token needs a name.", nil)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Token needs at least one scope.", nil)
+		return
+	}
+	// A token can't be given more than its creator has.
+	for _, scope := range params.Scopes {
+		if !principal.HasScope(scope) {
+			respondWithError(w, http.StatusForbidden, "Can't grant the "+scope+" scope.", nil)
+			return
+		}
+	}
+	if params.ExpiresInDays < 0 {
+		respondWithError(w, http.StatusBadRequest, "Expiry can't be in the past.", nil)
+		return
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+
+	var expiresAt sql.NullTime
+	if params.ExpiresInDays > 0 {
+		expiresAt = sql.NullTime{
+			Time:  time.Now().AddDate(0, 0, params.ExpiresInDays),
+			Valid: true,
+		}
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	tokenDB, err := qtx.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		ExpiresAt: expiresAt,
+		Name:      params.Name,
+		Scopes:    params.Scopes,
+		TokenHash: auth.HashToken(token),
+		UserID:    principal.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token, database error.", err)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:   "personal_token.created",
+		ActorID:  uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:   tokenDB.ID.String(),
+		Metadata: map[string]string{"scopes": strings.Join(params.Scopes, " ")},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create token, database error.", err)
+		return
+	}
+
+	resp := personalTokenDBToJSON(tokenDB)
+	resp.Token = token
+	respondWithJSON(w, http.StatusCreated, resp)
+}
+
+func (cfg *apiConfig) listPersonalTokens(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	tokensInDB, err := cfg.db.ListPersonalAccessTokens(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get tokens, database error.", err)
+		return
+	}
+
+	tokens := make([]PersonalAccessToken, len(tokensInDB))
+	for i, tokenDB := range tokensInDB {
+		tokens[i] = personalTokenDBToJSON(tokenDB)
+	}
+	respondWithJSON(w, http.StatusOK, tokens)
+}
+
+func (cfg *apiConfig) revokePersonalToken(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	tokenID, err := uuid.Parse(r.PathValue("tokenID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't revoke token, wrong UUID.", err)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	revoked, err := qtx.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: principal.UserID,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token, database error.", err)
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, http.StatusNotFound, "Token doesn't exist.", nil)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:  "personal_token.revoked",
+		ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:  tokenID.String(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke token, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func personalTokenDBToJSON(tokenDB database.PersonalAccessToken) PersonalAccessToken {
+	token := PersonalAccessToken{
+		ID:        tokenDB.ID,
+		CreatedAt: tokenDB.CreatedAt,
+		Name:      tokenDB.Name,
+		Scopes:    tokenDB.Scopes,
+	}
+	if tokenDB.LastUsedAt.Valid {
+		token.LastUsedAt = &tokenDB.LastUsedAt.Time
+	}
+	if tokenDB.ExpiresAt.Valid {
+		token.ExpiresAt = &tokenDB.ExpiresAt.Time
+	}
+	return token
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+func TestRevokeAllSessionsRevokesPersonalTokens(t *testing.T) {
+	cfg := testConfig(t)
+	ctx := context.Background()
+
+	userDB, err := cfg.db.CreateUser(ctx, database.CreateUserParams{
+		Email:          "pat-" + uuid.NewString() + "@example.com",
+		HashedPassword: unusablePassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteUserOnCleanup(t, cfg, userDB)
+
+	_, err = cfg.db.CreatePersonalAccessToken(ctx, database.CreatePersonalAccessTokenParams{
+		Name:      "ci",
+		Scopes:    []string{auth.ScopeChirpsWrite},
+		TokenHash: auth.HashToken(uuid.NewString()),
+		UserID:    userDB.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := cfg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	err = revokeAllSessionsTx(ctx, cfg.db.WithTx(tx), userDB.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := cfg.db.ListPersonalAccessTokens(ctx, userDB.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 0 {
+		t.Errorf("%d tokens listed after revoking every session, want 0", len(tokens))
+	}
+}
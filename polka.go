@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/webhook"
+)
+
+const (
+	// maxWebhookBody bounds the body that is read to check its signature.
+	maxWebhookBody = 1 << 20
+
+	defaultPolkaTolerance = 5 * time.Minute
+)
+
+// loadPolkaVerifier reads the keys Polka signs webhooks with from
+// POLKA_SIGNING_KEYS, a comma-separated list so that a new key can be
+// added before Polka switches over. It returns nil when no keys are set,
+// in which case Polka authenticates with POLKA_KEY alone.
+func loadPolkaVerifier() (*webhook.Verifier, error) {
+	var keys [][]byte
+	for _, key := range strings.Split(os.Getenv("POLKA_SIGNING_KEYS"), ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, []byte(key))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	tolerance := defaultPolkaTolerance
+	if s := os.Getenv("POLKA_SIGNATURE_TOLERANCE"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid POLKA_SIGNATURE_TOLERANCE %q", s)
+		}
+		tolerance = d
+	}
+	return webhook.NewVerifier(keys, tolerance), nil
+}
+
+var errWrongAPIKey = errors.New("wrong API key")
+
+// authenticatePolka checks that a webhook came from Polka. Once signing
+// keys are configured a valid signature is required, as a static API key
+// can be replayed. A signed request replayed within the tolerance window
+// carries the same signed event id, so the webhook_events inbox
+// acknowledges it without applying it again.
+func (cfg *apiConfig) authenticatePolka(h http.Header, body []byte) error {
+	if cfg.polkaVerifier != nil {
+		return cfg.polkaVerifier.Verify(h, body)
+	}
+	apiKey, err := auth.GetAPIKey(h)
+	if err != nil {
+		return err
+	}
+	if cfg.polkaKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.polkaKey)) != 1 {
+		return errWrongAPIKey
+	}
+	return nil
+}
+
+const polkaSource = "polka"
+
+type polkaEvent struct {
+	ID    string `json:"id"`
+	Event string `json:"event"`
+	// CreatedAt is when Polka sent the event. Without it the time the
+	// event was received is used.
+	CreatedAt *time.Time `json:"created_at"`
+	Data      struct {
+		UserID uuid.UUID `json:"user_id"`
+		// The billing period is optional; without it an upgrade or
+		// renewal lasts redPeriod from when it is received.
+		CurrentPeriodStart *time.Time `json:"current_period_start"`
+		CurrentPeriodEnd   *time.Time `json:"current_period_end"`
+	} `json:"data"`
+}
+
+// polkaEventID names an event for deduplication: Polka's own id, or the
+// Webhook-Id it was delivered with. It is empty when there is neither, as
+// the body alone can't tell a retry from a second event that happens to
+// look the same, such as a renewal.
+func polkaEventID(h http.Header, params polkaEvent) string {
+	if params.ID != "" {
+		return params.ID
+	}
+	return h.Get(webhook.IDHeader)
+}
+
+// makeUserRed receives Polka's webhooks. Every event is stored in the
+// webhook_events inbox first, so a retried delivery is acknowledged
+// without being applied twice and failures can be replayed by an admin.
+func (cfg *apiConfig) makeUserRed(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't read request.", err)
+		return
+	}
+
+	err = cfg.authenticatePolka(r.Header, body)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Can't authenticate", err)
+		return
+	}
+
+	params := polkaEvent{}
+	err = json.Unmarshal(body, &params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "No identifiable data", err)
+		return
+	}
+
+	eventID := polkaEventID(r.Header, params)
+	if eventID == "" {
+		respondWithError(w, http.StatusBadRequest, "Event has no id.", nil)
+		return
+	}
+	eventDB, err := cfg.db.CreateWebhookEvent(r.Context(), database.CreateWebhookEventParams{
+		Source:    polkaSource,
+		EventID:   eventID,
+		EventType: params.Event,
+		Payload:   body,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		eventDB, err = cfg.db.GetWebhookEvent(r.Context(), database.GetWebhookEventParams{
+			Source:  polkaSource,
+			EventID: eventID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't store event, database error.", err)
+			return
+		}
+		switch eventDB.Status {
+		case webhookEventProcessed, webhookEventIgnored:
+			respondWithJSON(w, http.StatusNoContent, "")
+			return
+		case webhookEventReceived:
+			respondWithError(w, http.StatusConflict, "Event is still being processed.", nil)
+			return
+		}
+		// Failed events are tried again.
+	} else if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store event, database error.", err)
+		return
+	}
+
+	err = cfg.processPolkaEvent(r, eventDB)
+	if errors.Is(err, errUnknownUser) {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't process event.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+
+}
+
+var errUnknownUser = errors.New("user doesn't exist")
+
+// processPolkaEvent applies a stored Polka event and records the outcome
+// on it.
+func (cfg *apiConfig) processPolkaEvent(r *http.Request, eventDB database.WebhookEvent) error {
+	err := cfg.applyPolkaEvent(r, eventDB)
+	if err != nil {
+		failErr := cfg.db.FailWebhookEvent(r.Context(), database.FailWebhookEventParams{
+			ID:        eventDB.ID,
+			LastError: nullString(err.Error()),
+		})
+		if failErr != nil {
+			log.Printf("Error recording failed webhook event %s: %s", eventDB.ID, failErr)
+		}
+	}
+	return err
+}
+
+// applyPolkaEvent moves the user's Chirpy Red subscription through its
+// lifecycle: user.upgraded and user.renewed start a new billing period,
+// user.downgraded cancels straight away and user.expired ends a
+// subscription that Polka gave up renewing. Polka only changes
+// subscriptions it paid for, which include the legacy ones migrated from
+// users who were Red before subscriptions were tracked, and an event older
+// than the last one applied is ignored, so a late retry can't undo a newer
+// event or an admin grant.
+func (cfg *apiConfig) applyPolkaEvent(r *http.Request, eventDB database.WebhookEvent) error {
+	params := polkaEvent{}
+	err := json.Unmarshal(eventDB.Payload, &params)
+	if err != nil {
+		return err
+	}
+
+	switch params.Event {
+	case "user.upgraded", "user.renewed", "user.downgraded", "user.expired":
+	default:
+		return cfg.db.FinishWebhookEvent(r.Context(), database.FinishWebhookEventParams{
+			ID:     eventDB.ID,
+			Status: webhookEventIgnored,
+		})
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userID := params.Data.UserID
+	_, err = qtx.GetUserByID(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errUnknownUser
+	}
+	if err != nil {
+		return err
+	}
+
+	sentAt := eventDB.ReceivedAt
+	if params.CreatedAt != nil {
+		sentAt = *params.CreatedAt
+	}
+	lastEventAt := sql.NullTime{Time: sentAt.UTC(), Valid: true}
+
+	var applied int64
+	switch params.Event {
+	case "user.upgraded", "user.renewed":
+		start := sentAt
+		if params.Data.CurrentPeriodStart != nil {
+			start = *params.Data.CurrentPeriodStart
+		}
+		end := start.Add(redPeriod)
+		if params.Data.CurrentPeriodEnd != nil {
+			end = *params.Data.CurrentPeriodEnd
+		}
+		applied, err = qtx.UpsertPolkaSubscription(r.Context(), database.UpsertPolkaSubscriptionParams{
+			UserID:             userID,
+			Plan:               planChirpyRed,
+			CurrentPeriodStart: start.UTC(),
+			CurrentPeriodEnd:   sql.NullTime{Time: end.UTC(), Valid: true},
+			LastEventAt:        lastEventAt,
+		})
+	case "user.downgraded":
+		applied, err = qtx.CancelPolkaSubscription(r.Context(), database.CancelPolkaSubscriptionParams{
+			UserID:      userID,
+			Plan:        planChirpyRed,
+			LastEventAt: lastEventAt,
+		})
+	case "user.expired":
+		applied, err = qtx.ExpirePolkaSubscription(r.Context(), database.ExpirePolkaSubscriptionParams{
+			UserID:      userID,
+			Plan:        planChirpyRed,
+			LastEventAt: lastEventAt,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if applied == 0 {
+		err = qtx.FinishWebhookEvent(r.Context(), database.FinishWebhookEventParams{
+			ID:     eventDB.ID,
+			Status: webhookEventIgnored,
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	if params.Event == "user.upgraded" {
+		err = publishEventTx(r.Context(), qtx, eventUserUpgraded, userID, map[string]any{
+			"user_id": userID,
+			"plan":    planChirpyRed,
+			"source":  polkaSource,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:   params.Event,
+		Target:   userID.String(),
+		Metadata: map[string]string{"source": polkaSource, "event_id": eventDB.EventID},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = qtx.FinishWebhookEvent(r.Context(), database.FinishWebhookEventParams{
+		ID:     eventDB.ID,
+		Status: webhookEventProcessed,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/webhook"
+)
+
+func TestAuthenticatePolka(t *testing.T) {
+	oldKey := []byte("old-polka-key")
+	newKey := []byte("new-polka-key")
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+
+	// polkaHeaders are the headers of a fake Polka sender.
+	polkaHeaders := func(at time.Time, keys ...[]byte) http.Header {
+		h := http.Header{}
+		webhook.SetHeaders(h, "evt_1", body, at, keys...)
+		return h
+	}
+	apiKeyHeaders := func(key string) http.Header {
+		h := http.Header{}
+		h.Set("Authorization", "ApiKey "+key)
+		return h
+	}
+	signing := &apiConfig{
+		polkaKey:      "static-key",
+		polkaVerifier: webhook.NewVerifier([][]byte{oldKey, newKey}, defaultPolkaTolerance),
+	}
+	static := &apiConfig{polkaKey: "static-key"}
+
+	tests := []struct {
+		name    string
+		cfg     *apiConfig
+		headers http.Header
+		body    []byte
+		wantErr error
+	}{
+		{name: "signed", cfg: signing, headers: polkaHeaders(time.Now(), newKey), body: body},
+		{name: "signed with old key", cfg: signing, headers: polkaHeaders(time.Now(), oldKey), body: body},
+		{name: "signed with unknown key", cfg: signing, headers: polkaHeaders(time.Now(), []byte("other")), body: body, wantErr: webhook.ErrInvalidSignature},
+		{name: "stale", cfg: signing, headers: polkaHeaders(time.Now().Add(-10*time.Minute), newKey), body: body, wantErr: webhook.ErrInvalidTimestamp},
+		{name: "future", cfg: signing, headers: polkaHeaders(time.Now().Add(10*time.Minute), newKey), body: body, wantErr: webhook.ErrInvalidTimestamp},
+		{name: "tampered body", cfg: signing, headers: polkaHeaders(time.Now(), newKey), body: []byte(`{"id":"evt_1","event":"user.downgraded"}`), wantErr: webhook.ErrInvalidSignature},
+		{name: "API key once signing is configured", cfg: signing, headers: apiKeyHeaders("static-key"), body: body, wantErr: webhook.ErrNoSignature},
+		{name: "API key", cfg: static, headers: apiKeyHeaders("static-key"), body: body},
+		{name: "wrong API key", cfg: static, headers: apiKeyHeaders("other-key"), body: body, wantErr: errWrongAPIKey},
+		{name: "no API key", cfg: static, headers: http.Header{}, body: body, wantErr: auth.ErrNoAuthHeader},
+		{name: "no API key configured", cfg: &apiConfig{}, headers: apiKeyHeaders("static-key"), body: body, wantErr: errWrongAPIKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.authenticatePolka(tt.headers, tt.body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("authenticatePolka() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolkaEventID(t *testing.T) {
+	tests := []struct {
+		name     string
+		bodyID   string
+		headerID string
+		want     string
+	}{
+		{name: "body id", bodyID: "evt_body", want: "evt_body"},
+		{name: "body id over header", bodyID: "evt_body", headerID: "evt_header", want: "evt_body"},
+		{name: "header id", headerID: "evt_header", want: "evt_header"},
+		{name: "no id", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.headerID != "" {
+				h.Set(webhook.IDHeader, tt.headerID)
+			}
+			params := polkaEvent{ID: tt.bodyID, Event: "user.renewed"}
+			if got := polkaEventID(h, params); got != tt.want {
+				t.Errorf("polkaEventID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestApplyPolkaEventLegacySubscription takes the subscriptions migrated
+// from users who were Red before subscriptions were tracked through
+// Polka's lifecycle events.
+func TestApplyPolkaEventLegacySubscription(t *testing.T) {
+	tests := []struct {
+		event      string
+		wantRed    bool
+		wantStatus string
+	}{
+		{event: "user.downgraded", wantRed: false, wantStatus: "canceled"},
+		{event: "user.expired", wantRed: false, wantStatus: "expired"},
+		{event: "user.renewed", wantRed: true, wantStatus: "active"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.event, func(t *testing.T) {
+			cfg := testConfig(t)
+			ctx := context.Background()
+
+			userDB, err := cfg.db.CreateUser(ctx, database.CreateUserParams{
+				Email:          "legacy-red-" + uuid.NewString() + "@example.com",
+				HashedPassword: unusablePassword,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			deleteUserOnCleanup(t, cfg, userDB)
+
+			// The row 017_subscriptions.sql writes for a Red user.
+			_, err = cfg.conn.Exec(`INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, source, current_period_start)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, 'active', 'legacy', NOW() - INTERVAL '1 day')`, userDB.ID, planChirpyRed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			payload, _ := json.Marshal(map[string]any{
+				"id":    "evt_" + uuid.NewString(),
+				"event": tt.event,
+				"data":  map[string]any{"user_id": userDB.ID},
+			})
+			eventDB, err := cfg.db.CreateWebhookEvent(ctx, database.CreateWebhookEventParams{
+				Source:    polkaSource,
+				EventID:   uuid.NewString(),
+				EventType: tt.event,
+				Payload:   payload,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", nil)
+			err = cfg.applyPolkaEvent(r, eventDB)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			eventDB, err = cfg.db.GetWebhookEventByID(ctx, eventDB.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if eventDB.Status != webhookEventProcessed {
+				t.Errorf("event %s, want %s", eventDB.Status, webhookEventProcessed)
+			}
+
+			var status string
+			err = cfg.conn.QueryRow("SELECT status FROM subscriptions WHERE user_id = $1", userDB.ID).Scan(&status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("subscription %s, want %s", status, tt.wantStatus)
+			}
+			red, err := cfg.isChirpyRed(ctx, userDB.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if red != tt.wantRed {
+				t.Errorf("isChirpyRed() = %v, want %v", red, tt.wantRed)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// pageParams reads the limit and offset query parameters of a list
+// endpoint.
+func pageParams(r *http.Request) (limit, offset int32, err error) {
+	limit = defaultPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		limit = int32(n)
+	}
+	if s := r.URL.Query().Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > 1<<30 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative number")
+		}
+		offset = int32(n)
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// bootstrapAdmin makes the caller the first admin when they present
+// BOOTSTRAP_ADMIN_TOKEN, as long as there is no admin yet. The admin scope
+// comes with the next login.
+func (cfg *apiConfig) bootstrapAdmin(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	if cfg.bootstrapAdminToken == "" {
+		respondWithError(w, http.StatusNotFound, "Admin bootstrap isn't enabled.", nil)
+		return
+	}
+
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Can't bootstrap admin, token missing.", err)
+		return
+	}
+
+	attempt, err := cfg.loginLimiter.Attempt(r.Context(), "bootstrap:"+clientIP(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't bootstrap admin right now.", err)
+		return
+	}
+	if attempt.Wait > 0 {
+		respondWithRetryAfter(w, attempt.Wait, "Too many failed attempts, try again later.")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(params.Token), []byte(cfg.bootstrapAdminToken)) != 1 {
+		cfg.recordAudit(r, auditEvent{
+			Action:  "admin.bootstrap_failed",
+			ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		})
+		respondWithError(w, http.StatusForbidden, "Wrong bootstrap token.", nil)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't bootstrap admin, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// Under READ COMMITTED two calls could both see no admin, so they take
+	// turns.
+	err = qtx.LockAdminBootstrap(r.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't bootstrap admin, database error.", err)
+		return
+	}
+
+	promoted, err := qtx.BootstrapAdmin(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't bootstrap admin, database error.", err)
+		return
+	}
+	if promoted == 0 {
+		respondWithError(w, http.StatusConflict, "There is an admin already.", nil)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:   "user.role_changed",
+		ActorID:  uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:   principal.UserID.String(),
+		Metadata: map[string]string{"role": auth.RoleAdmin, "via": "bootstrap"},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't bootstrap admin, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't bootstrap admin, database error.", err)
+		return
+	}
+
+	err = cfg.loginLimiter.Reset(r.Context(), "bootstrap:"+clientIP(r))
+	if err != nil {
+		log.Printf("Error resetting bootstrap attempts: %s", err)
+	}
+
+	userDB, err := cfg.db.GetUserByID(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user, database error.", err)
+		return
+	}
+	cfg.respondWithAdminUser(w, r, userDB)
+}
+
+func (cfg *apiConfig) setUserRole(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't change role, wrong UUID.", err)
+		return
+	}
+	if userID == principal.UserID {
+		respondWithError(w, http.StatusBadRequest, "Can't change your own role.", nil)
+		return
+	}
+
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil || !auth.ValidRole(params.Role) {
+		respondWithError(w, http.StatusBadRequest, "Can't change role, invalid role.", err)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change role, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userDB, err := qtx.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userID,
+		Role: params.Role,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:   "user.role_changed",
+		ActorID:  uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:   userID.String(),
+		Metadata: map[string]string{"role": params.Role},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change role, database error.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't change role, database error.", err)
+		return
+	}
+
+	cfg.respondWithAdminUser(w, r, userDB)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+}
+
+func (cfg *apiConfig) listSessions(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
+
+	sessionsInDB, err := cfg.db.ListActiveSessions(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get sessions, database error.", err)
+		return
+	}
+
+	sessions := make([]Session, len(sessionsInDB))
+	for i, sessionDB := range sessionsInDB {
+		sessions[i] = Session{
+			ID:         sessionDB.ID,
+			CreatedAt:  sessionDB.CreatedAt,
+			LastUsedAt: sessionDB.LastUsedAt,
+			UserAgent:  sessionDB.UserAgent,
+			IPAddress:  sessionDB.IpAddress,
+		}
+	}
+	respondWithJSON(w, http.StatusOK, sessions)
+}
+
+func (cfg *apiConfig) deleteSession(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
+
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't revoke session, wrong UUID.", err)
+		return
+	}
+
+	found, err := cfg.revokeSession(r, userID, sessionID, auditEvent{
+		Action:  "session.revoked",
+		ActorID: uuid.NullUUID{UUID: userID, Valid: true},
+		Target:  sessionID.String(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke session, database error.", err)
+		return
+	}
+	if !found {
+		respondWithError(w, http.StatusNotFound, "Session doesn't exist.", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+func (cfg *apiConfig) revokeAllUserSessions(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
+
+	err := cfg.revokeAllSessions(r, userID, auditEvent{
+		Action:  "session.revoked_all",
+		ActorID: uuid.NullUUID{UUID: userID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions, database error.", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
+
+// revokeSession ends one of userID's sessions together with its refresh
+// and access tokens, and records ev in the same transaction. It reports
+// false if the session doesn't exist, belongs to someone else or was
+// already revoked.
+func (cfg *apiConfig) revokeSession(r *http.Request, userID, sessionID uuid.UUID, ev auditEvent) (bool, error) {
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	revoked, err := qtx.RevokeSession(r.Context(), database.RevokeSessionParams{
+		ID:     sessionID,
+		UserID: userID,
+	})
+	if err != nil || revoked == 0 {
+		return false, err
+	}
+	err = qtx.RevokeSessionRefreshTokens(r.Context(), sessionID)
+	if err != nil {
+		return false, err
+	}
+	err = recordAuditTx(qtx, r, ev)
+	if err != nil {
+		return false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+	// Access tokens of the session stay valid until they expire unless
+	// they are refused explicitly.
+	return true, cfg.denylist.Revoke(r.Context(), "sid:"+sessionID.String(), time.Now().Add(accessTokenTTL))
+}
+
+// revokeAllSessions logs userID out everywhere and records ev in the same
+// transaction.
+func (cfg *apiConfig) revokeAllSessions(r *http.Request, userID uuid.UUID, ev auditEvent) error {
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = revokeAllSessionsTx(r.Context(), qtx, userID)
+	if err != nil {
+		return err
+	}
+	err = recordAuditTx(qtx, r, ev)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return cfg.denylist.RevokeUserBefore(r.Context(), userID, time.Now())
+}
+
+// revokeAllSessionsTx revokes userID's sessions, refresh tokens and
+// personal access tokens using qtx. Once the transaction is committed, the
+// user's access tokens still have to be refused with
+// denylist.RevokeUserBefore.
+func revokeAllSessionsTx(ctx context.Context, qtx *database.Queries, userID uuid.UUID) error {
+	err := qtx.RevokeAllSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = qtx.RevokeUserRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	return qtx.RevokeUserPersonalAccessTokens(ctx, userID)
+}
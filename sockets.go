@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/outbox"
+)
+
+const (
+	socketPingInterval = 30 * time.Second
+	// A client that doesn't answer a ping within socketWriteTimeout is
+	// gone.
+	socketWriteTimeout = 10 * time.Second
+	// socketSendBuffer is how many replies may wait to be written before
+	// the client is disconnected for sending too fast.
+	socketSendBuffer     = 16
+	maxSocketMessageSize = 4 << 10
+	maxSocketTopics      = 32
+	// Clients are warned this long before their token expires, so that
+	// they can reconnect with a fresh one.
+	socketExpiryWarning = time.Minute
+
+	// socketCloseTokenExpired is sent, from the range reserved for
+	// applications, when the token the socket was opened with expires or
+	// stops being accepted.
+	socketCloseTokenExpired websocket.StatusCode = 4001
+)
+
+// Topics a socket can subscribe to. A chirp thread is named
+// chirp:<chirp id>.
+const (
+	socketTopicTimeline    = "timeline"
+	socketTopicMentions    = "mentions"
+	socketTopicChirpPrefix = "chirp:"
+)
+
+// socketMessage is every message sent over a socket, in either direction.
+// Clients send subscribe and unsubscribe; the server answers with
+// subscribed, unsubscribed or error, and sends events with the domain
+// event type, its outbox seq and the topic they matched.
+type socketMessage struct {
+	Type      string          `json:"type"`
+	Topic     string          `json:"topic,omitempty"`
+	ID        int64           `json:"id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	ExpiresAt *time.Time      `json:"expires_at,omitempty"`
+}
+
+// socketClient is the state of one connection.
+type socketClient struct {
+	conn  *websocket.Conn
+	email string
+
+	mu     sync.Mutex
+	topics []string
+
+	// replies carries answers from the reading goroutine to the writing
+	// one.
+	replies chan socketMessage
+}
+
+// middlewareSocketToken accepts the access token as the access_token
+// query parameter, as browsers can't set headers on a WebSocket
+// handshake.
+func middlewareSocketToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleSocket upgrades to a WebSocket that delivers live chirp events
+// for the topics the client subscribes to. The socket is closed when the
+// token it was opened with expires, and when it is found to be refused
+// on one of the periodic checks, after a logout, a password change or a
+// suspension.
+func (cfg *apiConfig) handleSocket(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	userDB, err := cfg.db.GetUserByID(r.Context(), principal.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user, database error.", err)
+		return
+	}
+
+	// Sockets are opened with a token, never a cookie, so a page on
+	// another origin can't open one as the user.
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		// Accept has answered the request.
+		log.Printf("Error upgrading to WebSocket: %s", err)
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(maxSocketMessageSize)
+
+	client := &socketClient{
+		conn:    conn,
+		email:   strings.ToLower(userDB.Email),
+		replies: make(chan socketMessage, socketSendBuffer),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// The reader is blocked on the connection until it is closed.
+		defer conn.CloseNow()
+		cfg.writeSocket(ctx, client, principal.ExpiresAt, r.WithContext(ctx))
+	}()
+
+	client.read(ctx)
+	cancel()
+	wg.Wait()
+}
+
+// read handles the client's messages until the connection fails or is
+// closed. Reading also answers the client's pings and hands the writer
+// the client's pongs.
+func (c *socketClient) read(ctx context.Context) {
+	for {
+		_, data, err := c.conn.Read(ctx)
+		if err != nil {
+			return
+		}
+
+		msg := socketMessage{}
+		err = json.Unmarshal(data, &msg)
+		var reply socketMessage
+		switch {
+		case err != nil:
+			reply = socketMessage{Type: "error", Error: "invalid message"}
+		case msg.Type == "subscribe":
+			reply = c.subscribe(msg.Topic)
+		case msg.Type == "unsubscribe":
+			c.mu.Lock()
+			c.topics = slices.DeleteFunc(c.topics, func(t string) bool { return t == msg.Topic })
+			c.mu.Unlock()
+			reply = socketMessage{Type: "unsubscribed", Topic: msg.Topic}
+		default:
+			reply = socketMessage{Type: "error", Error: "unknown message type " + msg.Type}
+		}
+
+		select {
+		case c.replies <- reply:
+		default:
+			c.conn.Close(websocket.StatusPolicyViolation, "too many messages")
+			return
+		}
+	}
+}
+
+func (c *socketClient) subscribe(topic string) socketMessage {
+	switch {
+	case topic == socketTopicTimeline, topic == socketTopicMentions:
+	case strings.HasPrefix(topic, socketTopicChirpPrefix):
+		_, err := uuid.Parse(strings.TrimPrefix(topic, socketTopicChirpPrefix))
+		if err != nil {
+			return socketMessage{Type: "error", Topic: topic, Error: "invalid chirp id"}
+		}
+	default:
+		return socketMessage{Type: "error", Topic: topic, Error: "unknown topic"}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.Contains(c.topics, topic) {
+		if len(c.topics) >= maxSocketTopics {
+			return socketMessage{Type: "error", Topic: topic, Error: "too many topics"}
+		}
+		c.topics = append(c.topics, topic)
+	}
+	return socketMessage{Type: "subscribed", Topic: topic}
+}
+
+// writeSocket is the only writer of messages to the client, apart from
+// the pongs and closes the connection sends itself. It returns once the
+// client is gone, falls behind, or its token expires or is refused. The
+// credentials of the handshake request are checked again at every ping.
+func (cfg *apiConfig) writeSocket(ctx context.Context, c *socketClient, expiresAt time.Time, handshake *http.Request) {
+	sub := cfg.chirpFeed.hub.Subscribe()
+	defer sub.Close()
+
+	ping := time.NewTicker(socketPingInterval)
+	defer ping.Stop()
+
+	// Personal access tokens may never expire.
+	var warn, expire <-chan time.Time
+	if !expiresAt.IsZero() {
+		warnTimer := time.NewTimer(time.Until(expiresAt.Add(-socketExpiryWarning)))
+		defer warnTimer.Stop()
+		expireTimer := time.NewTimer(time.Until(expiresAt))
+		defer expireTimer.Stop()
+		warn, expire = warnTimer.C, expireTimer.C
+	}
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-sub.Done():
+			c.conn.Close(websocket.StatusTryAgainLater, "too slow")
+			return
+		case <-expire:
+			c.conn.Close(socketCloseTokenExpired, "token expired")
+			return
+		case <-warn:
+			err = c.send(ctx, socketMessage{Type: "token_expiring", ExpiresAt: &expiresAt})
+		case <-ping.C:
+			_, err = cfg.resolvePrincipal(handshake)
+			var authErr *authError
+			if errors.As(err, &authErr) {
+				c.conn.Close(socketCloseTokenExpired, authErr.msg)
+				return
+			}
+			// The socket stays open while the database can't be reached.
+			if err != nil {
+				log.Printf("Error checking socket token: %s", err)
+			}
+			err = c.ping(ctx)
+		case reply := <-c.replies:
+			err = c.send(ctx, reply)
+		case ev := <-sub.Events():
+			err = c.sendEvent(ctx, ev)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ping waits for the client to answer a ping.
+func (c *socketClient) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, socketWriteTimeout)
+	defer cancel()
+	return c.conn.Ping(ctx)
+}
+
+func (c *socketClient) send(ctx context.Context, msg socketMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, socketWriteTimeout)
+	defer cancel()
+	return c.conn.Write(ctx, websocket.MessageText, data)
+}
+
+// sendEvent sends ev once for every subscribed topic it belongs to.
+func (c *socketClient) sendEvent(ctx context.Context, ev outbox.Event) error {
+	c.mu.Lock()
+	topics := slices.Clone(c.topics)
+	c.mu.Unlock()
+	if len(topics) == 0 {
+		return nil
+	}
+
+	var chirp struct {
+		ID   uuid.UUID `json:"id"`
+		Body string    `json:"body"`
+	}
+	err := json.Unmarshal(ev.Payload, &chirp)
+	if err != nil {
+		log.Printf("Error reading domain event %d: %s", ev.Seq, err)
+		return nil
+	}
+	data, err := publicChirpEventData(ev)
+	if err != nil {
+		log.Printf("Error reading domain event %d: %s", ev.Seq, err)
+		return nil
+	}
+
+	for _, topic := range topics {
+		var match bool
+		switch topic {
+		case socketTopicTimeline:
+			match = ev.Type == eventChirpCreated || ev.Type == eventChirpDeleted
+		case socketTopicMentions:
+			match = ev.Type == eventChirpCreated && slices.Contains(mentionedEmails(chirp.Body), c.email)
+		default:
+			match = topic == socketTopicChirpPrefix+chirp.ID.String()
+		}
+		if !match {
+			continue
+		}
+		err = c.send(ctx, socketMessage{Type: ev.Type, Topic: topic, ID: ev.Seq, Data: data})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/outbox"
+)
+
+// socketServer serves handleSocket to a new user whose token expires at
+// expiresAt.
+func socketServer(t *testing.T, expiresAt time.Time) (*apiConfig, *httptest.Server) {
+	t.Helper()
+	cfg := testConfig(t)
+	cfg.chirpFeed = newChirpFeed(cfg.db)
+
+	userDB, err := cfg.db.CreateUser(context.Background(), database.CreateUserParams{
+		Email:          "socket-" + uuid.NewString() + "@example.com",
+		HashedPassword: unusablePassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteUserOnCleanup(t, cfg, userDB)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := auth.Principal{UserID: userDB.ID, ExpiresAt: expiresAt}
+		cfg.handleSocket(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	}))
+	t.Cleanup(server.Close)
+	return cfg, server
+}
+
+func readSocketMessage(t *testing.T, ctx context.Context, conn *websocket.Conn) socketMessage {
+	t.Helper()
+	_, data, err := conn.Read(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := socketMessage{}
+	err = json.Unmarshal(data, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestHandleSocket(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("not a handshake", func(t *testing.T) {
+		_, server := socketServer(t, time.Now().Add(time.Hour))
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 400 || resp.StatusCode >= 500 {
+			t.Errorf("plain GET = %d, want a client error", resp.StatusCode)
+		}
+	})
+
+	t.Run("subscribed events", func(t *testing.T) {
+		cfg, server := socketServer(t, time.Now().Add(time.Hour))
+		conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.CloseNow()
+
+		err = conn.Write(ctx, websocket.MessageText, []byte(`{"type":"subscribe","topic":"timeline"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg := readSocketMessage(t, ctx, conn); msg.Type != "subscribed" || msg.Topic != socketTopicTimeline {
+			t.Fatalf("reply = %+v, want subscribed to %s", msg, socketTopicTimeline)
+		}
+
+		payload, _ := json.Marshal(Chirp{ID: uuid.New(), Body: "hello"})
+		cfg.chirpFeed.hub.Publish(outbox.Event{Seq: 42, Type: eventChirpCreated, Payload: payload})
+		if msg := readSocketMessage(t, ctx, conn); msg.Type != eventChirpCreated || msg.ID != 42 {
+			t.Errorf("event = %+v, want %s with id 42", msg, eventChirpCreated)
+		}
+
+		err = conn.Close(websocket.StatusNormalClosure, "")
+		if err != nil {
+			t.Errorf("closing = %v, want the server to answer the close", err)
+		}
+	})
+
+	t.Run("token expiry", func(t *testing.T) {
+		_, server := socketServer(t, time.Now().Add(100*time.Millisecond))
+		conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.CloseNow()
+
+		if msg := readSocketMessage(t, ctx, conn); msg.Type != "token_expiring" {
+			t.Fatalf("first message = %+v, want token_expiring", msg)
+		}
+		_, _, err = conn.Read(ctx)
+		if status := websocket.CloseStatus(err); status != socketCloseTokenExpired {
+			t.Errorf("socket closed with %v (%v), want %d", status, err, socketCloseTokenExpired)
+		}
+	})
+}
@@ -0,0 +1,22 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (id, created_at, actor_id, action, target, ip_address, user_agent, metadata)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6
+);
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE (sqlc.narg('actor_id')::uuid IS NULL OR actor_id = sqlc.narg('actor_id'))
+AND (sqlc.narg('action')::text IS NULL OR action = sqlc.narg('action'))
+AND (sqlc.narg('target')::text IS NULL OR target = sqlc.narg('target'))
+AND (sqlc.narg('since')::timestamp IS NULL OR created_at >= sqlc.narg('since'))
+AND (sqlc.narg('until')::timestamp IS NULL OR created_at < sqlc.narg('until'))
+ORDER BY created_at DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
@@ -1,22 +1,25 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, published_at, reply_to_id)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    COALESCE(sqlc.narg('publish_at'), NOW()),
+    sqlc.narg('reply_to_id')
 )
 RETURNING *;
 
 -- name: GetAllChirps :many
 SELECT * FROM chirps
-ORDER BY created_at ASC;
+WHERE published_at <= NOW()
+ORDER BY published_at ASC;
 
 -- name: GetAllChirpsByAuthor :many
 SELECT * FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC;
+WHERE user_id = $1 AND published_at <= NOW()
+ORDER BY published_at ASC;
 
 -- name: GetOneChirp :one
 SELECT * FROM chirps
@@ -24,4 +27,14 @@ WHERE id = $1;
 
 -- name: DeleteAChirp :exec
 DELETE FROM chirps
-WHERE id = $1;
\ No newline at end of file
+WHERE id = $1;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps SET (body, updated_at) = ($2, NOW())
+WHERE id = $1
+RETURNING *;
+
+-- name: CountChirpsSince :one
+SELECT COUNT(*) AS count, COALESCE(MIN(created_at), NOW())::timestamp AS oldest
+FROM chirps
+WHERE user_id = $1 AND created_at > $2;
@@ -0,0 +1,42 @@
+-- name: GetOrCreateConversation :one
+INSERT INTO conversations (id, created_at, updated_at, user_a_id, user_b_id)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2)
+ON CONFLICT (user_a_id, user_b_id) DO UPDATE
+SET user_a_id = EXCLUDED.user_a_id
+RETURNING *;
+
+-- name: GetConversation :one
+SELECT * FROM conversations
+WHERE id = sqlc.arg('id')
+AND (user_a_id = sqlc.arg('user_id') OR user_b_id = sqlc.arg('user_id'));
+
+-- name: ListConversations :many
+SELECT conversations.*, (
+    SELECT COUNT(*) FROM direct_messages
+    WHERE direct_messages.conversation_id = conversations.id
+    AND direct_messages.sender_id <> sqlc.arg('user_id')
+    AND direct_messages.read_at IS NULL
+) AS unread_count
+FROM conversations
+WHERE user_a_id = sqlc.arg('user_id') OR user_b_id = sqlc.arg('user_id')
+ORDER BY updated_at DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: TouchConversation :exec
+UPDATE conversations SET updated_at = NOW()
+WHERE id = $1;
+
+-- name: CreateDirectMessage :one
+INSERT INTO direct_messages (id, created_at, conversation_id, sender_id, body)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3)
+RETURNING *;
+
+-- name: ListDirectMessages :many
+SELECT * FROM direct_messages
+WHERE conversation_id = $1
+ORDER BY created_at DESC
+LIMIT $2 OFFSET $3;
+
+-- name: MarkConversationRead :execrows
+UPDATE direct_messages SET read_at = NOW()
+WHERE conversation_id = $1 AND sender_id <> $2 AND read_at IS NULL;
@@ -0,0 +1,48 @@
+-- name: CreateDomainEvent :exec
+INSERT INTO domain_events (id, occurred_at, type, user_id, payload, next_attempt_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    NOW()
+)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: ClaimDomainEvents :many
+UPDATE domain_events
+SET next_attempt_at = sqlc.arg('lease_until')
+WHERE id IN (
+    SELECT id FROM domain_events
+    WHERE dispatched_at IS NULL AND next_attempt_at <= NOW()
+    ORDER BY seq
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, seq, occurred_at, type, user_id, payload, attempts;
+
+-- name: MarkDomainEventDispatched :exec
+UPDATE domain_events
+SET (dispatched_at, attempts, last_error) = (NOW(), attempts + 1, NULL)
+WHERE id = $1;
+
+-- name: FailDomainEvent :exec
+UPDATE domain_events
+SET (attempts, next_attempt_at, last_error) = (attempts + 1, $2, $3)
+WHERE id = $1;
+
+-- name: GetDomainEventBySeq :one
+SELECT * FROM domain_events
+WHERE seq = $1;
+
+-- name: ListDomainEventsAfter :many
+SELECT * FROM domain_events
+WHERE seq > sqlc.arg('after')
+AND type = ANY(sqlc.arg('types')::text[])
+AND (sqlc.narg('user_id')::uuid IS NULL OR user_id = sqlc.narg('user_id'))
+ORDER BY seq
+LIMIT sqlc.arg('limit');
+
+-- name: LatestDomainEventSeq :one
+SELECT COALESCE(MAX(seq), 0)::bigint FROM domain_events;
@@ -0,0 +1,8 @@
+-- name: FollowUser :execrows
+INSERT INTO follows (follower_id, followed_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (follower_id, followed_id) DO NOTHING;
+
+-- name: UnfollowUser :execrows
+DELETE FROM follows
+WHERE follower_id = $1 AND followed_id = $2;
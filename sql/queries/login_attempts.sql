@@ -0,0 +1,29 @@
+-- name: GetLoginAttempts :one
+SELECT * FROM login_attempts
+WHERE key = $1;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_attempts (key, failures, last_failed_at)
+VALUES (
+    sqlc.arg(key),
+    1,
+    sqlc.arg(failed_at)
+)
+ON CONFLICT (key) DO UPDATE SET
+    failures = CASE
+        WHEN login_attempts.last_failed_at < sqlc.arg(window_start) THEN 1
+        ELSE login_attempts.failures + 1
+    END,
+    last_failed_at = sqlc.arg(failed_at)
+WHERE login_attempts.failures = sqlc.arg(seen_failures)
+AND login_attempts.last_failed_at = sqlc.arg(seen_last_failed_at)
+RETURNING *;
+
+-- name: ForgiveLoginFailure :exec
+UPDATE login_attempts
+SET failures = failures - 1
+WHERE key = $1 AND failures > 0;
+
+-- name: ResetLoginAttempts :exec
+DELETE FROM login_attempts
+WHERE key = $1;
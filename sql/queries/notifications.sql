@@ -0,0 +1,54 @@
+-- name: AddNotification :execrows
+INSERT INTO notifications (id, created_at, updated_at, user_id, type, group_key, actor_ids, subject_id)
+SELECT
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    sqlc.arg('user_id'),
+    sqlc.arg('type'),
+    sqlc.arg('group_key'),
+    CASE WHEN sqlc.narg('actor_id')::uuid IS NULL THEN '{}'::uuid[] ELSE ARRAY[sqlc.narg('actor_id')::uuid] END,
+    sqlc.narg('subject_id')
+WHERE NOT EXISTS (
+    SELECT 1 FROM notification_mutes
+    WHERE notification_mutes.user_id = sqlc.arg('user_id') AND notification_mutes.type = sqlc.arg('type')
+)
+ON CONFLICT (user_id, group_key) WHERE read_at IS NULL DO UPDATE
+SET (updated_at, actor_ids, subject_id) = (
+    NOW(),
+    EXCLUDED.actor_ids || array_remove(notifications.actor_ids, EXCLUDED.actor_ids[1]),
+    COALESCE(EXCLUDED.subject_id, notifications.subject_id)
+);
+
+-- name: ListNotifications :many
+SELECT * FROM notifications
+WHERE user_id = sqlc.arg('user_id')
+AND (NOT sqlc.arg('unread_only')::bool OR read_at IS NULL)
+ORDER BY updated_at DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
+
+-- name: CountUnreadNotifications :one
+SELECT COUNT(*) FROM notifications
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MarkNotificationRead :execrows
+UPDATE notifications SET read_at = COALESCE(read_at, NOW())
+WHERE id = $1 AND user_id = $2;
+
+-- name: MarkAllNotificationsRead :exec
+UPDATE notifications SET read_at = NOW()
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: MuteNotificationType :exec
+INSERT INTO notification_mutes (user_id, type, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (user_id, type) DO NOTHING;
+
+-- name: UnmuteNotificationType :exec
+DELETE FROM notification_mutes
+WHERE user_id = $1 AND type = $2;
+
+-- name: ListMutedNotificationTypes :many
+SELECT type FROM notification_mutes
+WHERE user_id = $1
+ORDER BY type;
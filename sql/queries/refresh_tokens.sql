@@ -1,29 +1,36 @@
 -- name: CreateRefToken :one
 INSERT INTO refresh_tokens (
-    token ,
+    token_hash ,
     created_at ,
     updated_at ,   
     expires_at ,  
-    user_id )
+    user_id ,
+    session_id )
 VALUES (
     $1,
     NOW(),
     NOW(),
     $3,
-    $2
+    $2,
+    $4
 )
 RETURNING *;
 
 
 -- name: GetUserFromRefreshToken :one
 SELECT * FROM refresh_tokens
-WHERE token = $1;
+WHERE token_hash = $1;
 
 
  
--- name: RevokeRefreshToken :exec
+-- name: RevokeRefreshToken :execrows
 UPDATE refresh_tokens SET (updated_at, revoked_at) = (NOW(), NOW())
-WHERE token = $1;
+WHERE token_hash = $1 AND revoked_at IS NULL;
 
+-- name: RevokeSessionRefreshTokens :exec
+UPDATE refresh_tokens SET (updated_at, revoked_at) = (NOW(), NOW())
+WHERE session_id = $1 AND revoked_at IS NULL;
 
- 
\ No newline at end of file
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens SET (updated_at, revoked_at) = (NOW(), NOW())
+WHERE user_id = $1 AND revoked_at IS NULL;
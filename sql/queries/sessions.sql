@@ -0,0 +1,33 @@
+-- name: CreateSession :one
+INSERT INTO sessions (id, created_at, last_used_at, user_agent, ip_address, user_id)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3
+)
+RETURNING *;
+
+-- name: TouchSession :exec
+UPDATE sessions SET (last_used_at, user_agent, ip_address) = (NOW(), $2, $3)
+WHERE id = $1;
+
+-- name: ListActiveSessions :many
+SELECT * FROM sessions
+WHERE user_id = $1 AND revoked_at IS NULL AND EXISTS (
+    SELECT 1 FROM refresh_tokens
+    WHERE refresh_tokens.session_id = sessions.id
+    AND refresh_tokens.revoked_at IS NULL
+    AND refresh_tokens.expires_at > NOW()
+)
+ORDER BY last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE sessions SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllSessions :exec
+UPDATE sessions SET revoked_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
@@ -0,0 +1,76 @@
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, source, current_period_start, current_period_end)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    'active',
+    $3,
+    $4,
+    $5
+)
+ON CONFLICT (user_id, plan) DO UPDATE
+SET (updated_at, status, source, current_period_start, current_period_end, canceled_at) =
+    (NOW(), 'active', EXCLUDED.source, EXCLUDED.current_period_start, EXCLUDED.current_period_end, NULL)
+RETURNING *;
+
+-- name: CancelSubscription :execrows
+UPDATE subscriptions
+SET (updated_at, status, current_period_end, canceled_at) = (NOW(), 'canceled', NOW(), NOW())
+WHERE user_id = $1 AND plan = $2 AND status = 'active';
+
+-- name: UpsertPolkaSubscription :execrows
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, source, current_period_start, current_period_end, last_event_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    'active',
+    'polka',
+    $3,
+    $4,
+    $5
+)
+ON CONFLICT (user_id, plan) DO UPDATE
+SET (updated_at, status, source, current_period_start, current_period_end, canceled_at, last_event_at) =
+    (NOW(), 'active', 'polka', EXCLUDED.current_period_start, EXCLUDED.current_period_end, NULL, EXCLUDED.last_event_at)
+WHERE (subscriptions.source NOT IN ('polka', 'legacy') AND subscriptions.status <> 'active')
+OR (
+    subscriptions.source IN ('polka', 'legacy')
+    AND subscriptions.current_period_start <= EXCLUDED.current_period_start
+    AND (subscriptions.last_event_at IS NULL OR subscriptions.last_event_at < EXCLUDED.last_event_at)
+);
+
+-- name: CancelPolkaSubscription :execrows
+UPDATE subscriptions
+SET (updated_at, status, current_period_end, canceled_at, last_event_at) = (NOW(), 'canceled', NOW(), NOW(), $3)
+WHERE user_id = $1 AND plan = $2 AND status = 'active' AND source IN ('polka', 'legacy')
+AND (last_event_at IS NULL OR last_event_at < $3);
+
+-- name: ExpirePolkaSubscription :execrows
+UPDATE subscriptions
+SET (updated_at, status, last_event_at) = (NOW(), 'expired', $3)
+WHERE user_id = $1 AND plan = $2 AND status = 'active' AND source IN ('polka', 'legacy')
+AND (last_event_at IS NULL OR last_event_at < $3);
+
+-- name: ExpireLapsedSubscriptions :many
+UPDATE subscriptions
+SET (updated_at, status) = (NOW(), 'expired')
+WHERE status = 'active' AND current_period_end <= NOW()
+RETURNING *;
+
+-- name: HasActiveSubscription :one
+SELECT EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE user_id = $1 AND plan = $2 AND status = 'active'
+    AND (current_period_end IS NULL OR current_period_end > NOW())
+);
+
+-- name: ListActivePlans :many
+SELECT plan FROM subscriptions
+WHERE user_id = $1 AND status = 'active'
+AND (current_period_end IS NULL OR current_period_end > NOW());
@@ -0,0 +1,23 @@
+-- name: RevokeToken :exec
+INSERT INTO revoked_tokens (id, expires_at)
+VALUES ($1, $2)
+ON CONFLICT (id) DO UPDATE SET expires_at = GREATEST(revoked_tokens.expires_at, EXCLUDED.expires_at);
+
+-- name: IsTokenRevoked :one
+SELECT EXISTS (
+    SELECT 1 FROM revoked_tokens
+    WHERE id = $1 AND expires_at > NOW()
+);
+
+-- name: DeleteExpiredRevokedTokens :exec
+DELETE FROM revoked_tokens
+WHERE expires_at <= NOW();
+
+-- name: SetUserTokenCutoff :exec
+INSERT INTO user_token_cutoffs (user_id, not_before)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET not_before = GREATEST(user_token_cutoffs.not_before, EXCLUDED.not_before);
+
+-- name: GetUserTokenCutoff :one
+SELECT not_before FROM user_token_cutoffs
+WHERE user_id = $1;
@@ -0,0 +1,6 @@
+-- name: UseTOTPStep :execrows
+INSERT INTO totp_steps (user_id, last_step, used_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (user_id) DO UPDATE
+SET (last_step, used_at) = (EXCLUDED.last_step, EXCLUDED.used_at)
+WHERE totp_steps.last_step < EXCLUDED.last_step;
@@ -22,7 +22,55 @@ RETURNING *;
 DELETE FROM users;    
 
 
--- name: MakeUserRed :one
-UPDATE users SET (is_chirpy_red, updated_at) = (true, NOW())
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: SetTOTPSecret :exec
+UPDATE users SET (totp_secret, totp_enabled, updated_at) = ($2, false, NOW())
+WHERE id = $1;
+
+-- name: EnableTOTP :exec
+UPDATE users SET (totp_enabled, updated_at) = (true, NOW())
+WHERE id = $1;
+
+-- name: SetUserRole :one
+UPDATE users SET (role, updated_at) = ($2, NOW())
 WHERE id = $1
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: LockAdminBootstrap :exec
+SELECT pg_advisory_xact_lock(hashtext('chirpy.bootstrap_admin'));
+
+-- name: BootstrapAdmin :execrows
+UPDATE users SET (role, updated_at) = ('admin', NOW())
+WHERE id = $1
+AND NOT EXISTS (SELECT 1 FROM users WHERE role = 'admin');
+
+-- name: ListUsers :many
+SELECT sqlc.embed(users), EXISTS (
+    SELECT 1 FROM subscriptions
+    WHERE subscriptions.user_id = users.id AND plan = 'chirpy_red' AND status = 'active'
+    AND (current_period_end IS NULL OR current_period_end > NOW())
+) AS is_chirpy_red
+FROM users
+WHERE sqlc.arg(search)::text = ''
+OR email ILIKE '%' || replace(replace(replace(sqlc.arg(search)::text, '\', '\\'), '%', '\%'), '_', '\_') || '%' ESCAPE '\'
+ORDER BY created_at
+LIMIT $2 OFFSET $3;
+
+-- name: SuspendUser :one
+UPDATE users SET (suspended_at, updated_at) = (NOW(), NOW())
+WHERE id = $1
+RETURNING *;
+
+-- name: UnsuspendUser :one
+UPDATE users SET (suspended_at, updated_at) = (NULL, NOW())
+WHERE id = $1
+RETURNING *;
+
+-- name: GetUserByEmail :one
+SELECT * FROM users
+WHERE lower(email) = lower($1)
+ORDER BY created_at
+LIMIT 1;
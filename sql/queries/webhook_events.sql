@@ -0,0 +1,37 @@
+-- name: CreateWebhookEvent :one
+INSERT INTO webhook_events (id, received_at, updated_at, source, event_id, event_type, payload)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+ON CONFLICT (source, event_id) DO NOTHING
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE source = $1 AND event_id = $2;
+
+-- name: GetWebhookEventByID :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: FinishWebhookEvent :exec
+UPDATE webhook_events
+SET (status, processed_at, updated_at, attempts, last_error) = ($2, NOW(), NOW(), attempts + 1, NULL)
+WHERE id = $1;
+
+-- name: FailWebhookEvent :exec
+UPDATE webhook_events
+SET (status, updated_at, attempts, last_error) = ('failed', NOW(), attempts + 1, $2)
+WHERE id = $1;
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status')
+ORDER BY received_at DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
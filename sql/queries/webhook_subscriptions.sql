@@ -0,0 +1,71 @@
+-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions (id, created_at, updated_at, user_id, url, event_types, secret, all_users)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5
+)
+RETURNING *;
+
+-- name: GetWebhookSubscription :one
+SELECT * FROM webhook_subscriptions
+WHERE id = $1 AND user_id = $2;
+
+-- name: ListWebhookSubscriptions :many
+SELECT * FROM webhook_subscriptions
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: DeleteWebhookSubscription :execrows
+DELETE FROM webhook_subscriptions
+WHERE id = $1 AND user_id = $2;
+
+-- name: EnqueueWebhookDeliveries :execrows
+INSERT INTO webhook_deliveries (id, created_at, updated_at, subscription_id, event_type, payload, next_attempt_at, event_id)
+SELECT gen_random_uuid(), NOW(), NOW(), webhook_subscriptions.id, sqlc.arg('event_type')::text, sqlc.arg('payload')::jsonb, NOW(), sqlc.arg('event_id')
+FROM webhook_subscriptions
+WHERE sqlc.arg('event_type')::text = ANY(event_types)
+AND (all_users OR user_id = sqlc.arg('user_id'))
+ON CONFLICT (subscription_id, event_id) DO NOTHING;
+
+-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries
+SET (next_attempt_at, updated_at) = (sqlc.arg('lease_until'), NOW())
+FROM webhook_subscriptions
+WHERE webhook_subscriptions.id = webhook_deliveries.subscription_id
+AND webhook_deliveries.id IN (
+    SELECT id FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING webhook_deliveries.id, webhook_deliveries.event_type, webhook_deliveries.payload, webhook_deliveries.attempts, webhook_subscriptions.url, webhook_subscriptions.secret;
+
+-- name: SucceedWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET (status, attempts, last_attempt_at, updated_at, response_status, last_error) = ('succeeded', attempts + 1, NOW(), NOW(), $2, NULL)
+WHERE id = $1;
+
+-- name: FailWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET (status, attempts, next_attempt_at, last_attempt_at, updated_at, response_status, last_error) = ($2, attempts + 1, $3, NOW(), NOW(), $4, $5)
+WHERE id = $1;
+
+-- name: RetryWebhookDelivery :one
+UPDATE webhook_deliveries
+SET (status, attempts, next_attempt_at, updated_at) = ('pending', 0, NOW(), NOW())
+WHERE id = $1 AND subscription_id = $2 AND status = 'dead'
+RETURNING *;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE subscription_id = sqlc.arg('subscription_id')
+AND (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status'))
+ORDER BY created_at DESC
+LIMIT sqlc.arg('limit') OFFSET sqlc.arg('offset');
@@ -0,0 +1,32 @@
+-- +goose Up
+CREATE TABLE sessions(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    user_agent TEXT NOT NULL,
+    ip_address TEXT NOT NULL,
+    user_id UUID NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Refresh tokens issued before sessions existed each become a session of
+-- their own, with no device information.
+ALTER TABLE refresh_tokens
+ADD COLUMN session_id UUID;
+
+UPDATE refresh_tokens SET session_id = gen_random_uuid();
+
+INSERT INTO sessions (id, created_at, last_used_at, revoked_at, user_agent, ip_address, user_id)
+SELECT session_id, created_at, updated_at, revoked_at, '', '', user_id
+FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens
+ALTER COLUMN session_id SET NOT NULL,
+ADD FOREIGN KEY(session_id) REFERENCES sessions(id) ON DELETE CASCADE;
+
+-- +goose Down
+ALTER TABLE refresh_tokens
+DROP COLUMN session_id;
+
+DROP TABLE sessions;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- Existing tokens are re-keyed in place so that nobody is logged out: the
+-- stored value becomes the SHA-256 digest of the token the client holds.
+ALTER TABLE refresh_tokens
+RENAME COLUMN token TO token_hash;
+
+UPDATE refresh_tokens SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex');
+
+-- +goose Down
+-- Digests can't be turned back into tokens, so every session is ended.
+DELETE FROM refresh_tokens;
+
+UPDATE sessions SET revoked_at = NOW()
+WHERE revoked_at IS NULL;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token_hash TO token;
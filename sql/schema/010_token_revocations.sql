@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE revoked_tokens(
+    id TEXT PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE user_token_cutoffs(
+    user_id UUID PRIMARY KEY,
+    not_before TIMESTAMP NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE user_token_cutoffs;
+
+DROP TABLE revoked_tokens;
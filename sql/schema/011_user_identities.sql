@@ -0,0 +1,25 @@
+-- +goose Up
+CREATE TABLE user_identities(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL,
+    user_id UUID NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE(provider, subject)
+);
+
+CREATE TABLE oidc_login_states(
+    state TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL
+);
+
+-- +goose Down
+DROP TABLE oidc_login_states;
+
+DROP TABLE user_identities;
@@ -0,0 +1,21 @@
+-- +goose Up
+CREATE TABLE webhook_events(
+    id UUID PRIMARY KEY,
+    received_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP,
+    source TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'received'
+    CHECK (status IN ('received', 'processed', 'ignored', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    UNIQUE(source, event_id)
+);
+
+CREATE INDEX webhook_events_status_idx ON webhook_events(status, received_at);
+
+-- +goose Down
+DROP TABLE webhook_events;
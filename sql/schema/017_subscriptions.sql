@@ -0,0 +1,42 @@
+-- +goose Up
+-- A user has at most one subscription per plan, which is renewed in
+-- place. current_period_end is NULL for grants that don't lapse.
+CREATE TABLE subscriptions(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    plan TEXT NOT NULL,
+    status TEXT NOT NULL
+    CHECK (status IN ('active', 'canceled', 'expired')),
+    source TEXT NOT NULL,
+    current_period_start TIMESTAMP NOT NULL,
+    current_period_end TIMESTAMP,
+    canceled_at TIMESTAMP,
+    UNIQUE(user_id, plan),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX subscriptions_active_end_idx ON subscriptions(current_period_end)
+WHERE status = 'active';
+
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, plan, status, source, current_period_start)
+SELECT gen_random_uuid(), NOW(), NOW(), id, 'chirpy_red', 'active', 'legacy', updated_at
+FROM users
+WHERE is_chirpy_red;
+
+ALTER TABLE users
+DROP COLUMN is_chirpy_red;
+
+-- +goose Down
+ALTER TABLE users
+ADD COLUMN is_chirpy_red BOOLEAN DEFAULT false;
+
+UPDATE users SET is_chirpy_red = true
+WHERE id IN (
+    SELECT user_id FROM subscriptions
+    WHERE plan = 'chirpy_red' AND status = 'active'
+    AND (current_period_end IS NULL OR current_period_end > NOW())
+);
+
+DROP TABLE subscriptions;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- published_at is in the future for scheduled chirps, which stay hidden
+-- from everyone but their author until then.
+ALTER TABLE chirps
+ADD COLUMN published_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+UPDATE chirps SET published_at = created_at;
+
+CREATE INDEX chirps_user_id_created_at_idx ON chirps(user_id, created_at);
+
+-- +goose Down
+DROP INDEX chirps_user_id_created_at_idx;
+
+ALTER TABLE chirps
+DROP COLUMN published_at;
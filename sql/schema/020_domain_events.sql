@@ -0,0 +1,44 @@
+-- +goose Up
+-- Events are written in the same transaction as the change they describe
+-- and handed to subscribers afterwards. user_id names the user the event
+-- is about and has no foreign key, like audit_events.actor_id.
+CREATE TABLE domain_events(
+    id UUID PRIMARY KEY,
+    seq BIGSERIAL NOT NULL UNIQUE,
+    occurred_at TIMESTAMP NOT NULL,
+    type TEXT NOT NULL,
+    user_id UUID NOT NULL,
+    payload JSONB NOT NULL,
+    dispatched_at TIMESTAMP,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT
+);
+
+CREATE INDEX domain_events_pending_idx ON domain_events(next_attempt_at) WHERE dispatched_at IS NULL;
+
+-- Listeners are woken when an event is committed instead of waiting for
+-- the next poll.
+-- +goose StatementBegin
+CREATE FUNCTION domain_events_notify() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('domain_events', NEW.seq::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER domain_events_notify
+AFTER INSERT ON domain_events
+FOR EACH ROW EXECUTE FUNCTION domain_events_notify();
+
+-- Subscribers may see an event more than once, so a webhook delivery
+-- remembers the event it was queued for.
+ALTER TABLE webhook_deliveries ADD COLUMN event_id UUID;
+ALTER TABLE webhook_deliveries ADD UNIQUE(subscription_id, event_id);
+
+-- +goose Down
+ALTER TABLE webhook_deliveries DROP COLUMN event_id;
+DROP TRIGGER domain_events_notify ON domain_events;
+DROP FUNCTION domain_events_notify();
+DROP TABLE domain_events;
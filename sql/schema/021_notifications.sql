@@ -0,0 +1,31 @@
+-- +goose Up
+-- While a notification is unread, similar ones, those with the same
+-- group_key, are folded into it: actor_ids collects who caused them,
+-- latest first.
+CREATE TABLE notifications(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL,
+    type TEXT NOT NULL,
+    group_key TEXT NOT NULL,
+    actor_ids UUID[] NOT NULL DEFAULT '{}',
+    subject_id UUID,
+    read_at TIMESTAMP,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX notifications_unread_group_idx ON notifications(user_id, group_key) WHERE read_at IS NULL;
+CREATE INDEX notifications_user_id_idx ON notifications(user_id, updated_at);
+
+CREATE TABLE notification_mutes(
+    user_id UUID NOT NULL,
+    type TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY(user_id, type),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE notification_mutes;
+DROP TABLE notifications;
@@ -0,0 +1,43 @@
+-- +goose Up
+-- A conversation is between two users, stored with the lower id first so
+-- that each pair has only one.
+CREATE TABLE conversations(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_a_id UUID NOT NULL,
+    user_b_id UUID NOT NULL,
+    CHECK (user_a_id < user_b_id),
+    UNIQUE(user_a_id, user_b_id),
+    FOREIGN KEY(user_a_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(user_b_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX conversations_user_b_id_idx ON conversations(user_b_id);
+
+CREATE TABLE direct_messages(
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    conversation_id UUID NOT NULL,
+    sender_id UUID NOT NULL,
+    body TEXT NOT NULL,
+    read_at TIMESTAMP,
+    FOREIGN KEY(conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY(sender_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX direct_messages_conversation_id_idx ON direct_messages(conversation_id, created_at);
+
+CREATE TABLE user_blocks(
+    blocker_id UUID NOT NULL,
+    blocked_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY(blocker_id, blocked_id),
+    FOREIGN KEY(blocker_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(blocked_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE user_blocks;
+DROP TABLE direct_messages;
+DROP TABLE conversations;
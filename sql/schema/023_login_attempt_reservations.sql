@@ -0,0 +1,7 @@
+-- +goose Up
+-- Attempts are counted before they are made, so the limiter needs the
+-- time of the attempt before the latest one to decide on the latest.
+ALTER TABLE login_attempts ADD COLUMN previous_failed_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE login_attempts DROP COLUMN previous_failed_at;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- last_step is the latest TOTP time step a user logged in with; codes of
+-- that step or an earlier one are refused so they can't be replayed.
+CREATE TABLE totp_steps(
+    user_id UUID PRIMARY KEY,
+    last_step BIGINT NOT NULL,
+    used_at TIMESTAMP NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- +goose Down
+DROP TABLE totp_steps;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- last_event_at is when the latest Polka event applied to a subscription
+-- was sent, so that an event delivered out of order can be told apart
+-- from a newer one and ignored.
+ALTER TABLE subscriptions ADD COLUMN last_event_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE subscriptions DROP COLUMN last_event_at;
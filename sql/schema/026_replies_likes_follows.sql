@@ -0,0 +1,37 @@
+-- +goose Up
+-- reply_to_id is the chirp a chirp answers. A reply stays when the chirp
+-- it answers is deleted.
+ALTER TABLE chirps
+ADD COLUMN reply_to_id UUID REFERENCES chirps(id) ON DELETE SET NULL;
+
+CREATE INDEX chirps_reply_to_id_idx ON chirps(reply_to_id);
+
+CREATE TABLE chirp_likes(
+    user_id UUID NOT NULL,
+    chirp_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY(user_id, chirp_id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(chirp_id) REFERENCES chirps(id) ON DELETE CASCADE
+);
+
+CREATE INDEX chirp_likes_chirp_id_idx ON chirp_likes(chirp_id);
+
+CREATE TABLE follows(
+    follower_id UUID NOT NULL,
+    followed_id UUID NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY(follower_id, followed_id),
+    CHECK (follower_id <> followed_id),
+    FOREIGN KEY(follower_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(followed_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX follows_followed_id_idx ON follows(followed_id);
+
+-- +goose Down
+DROP TABLE follows;
+DROP TABLE chirp_likes;
+
+ALTER TABLE chirps
+DROP COLUMN reply_to_id;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Attempts are checked before they are recorded again, and only recorded
+-- if they are allowed, so the limiter no longer needs the time of the
+-- failure before the latest one.
+ALTER TABLE login_attempts DROP COLUMN previous_failed_at;
+
+-- +goose Down
+ALTER TABLE login_attempts ADD COLUMN previous_failed_at TIMESTAMP;
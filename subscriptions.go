@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+const (
+	planChirpyRed = "chirpy_red"
+
+	// subscriptionSourceAdmin marks subscriptions granted by an admin
+	// rather than paid for through Polka.
+	subscriptionSourceAdmin = "admin"
+
+	// redPeriod is how long an upgrade or renewal lasts when Polka doesn't
+	// send the end of the billing period.
+	redPeriod = 30 * 24 * time.Hour
+
+	subscriptionExpiryInterval = time.Minute
+)
+
+// isChirpyRed reports whether userID has a Chirpy Red subscription that
+// hasn't lapsed. It doesn't wait for the expiry job to catch up.
+func (cfg *apiConfig) isChirpyRed(ctx context.Context, userID uuid.UUID) (bool, error) {
+	return cfg.db.HasActiveSubscription(ctx, database.HasActiveSubscriptionParams{
+		UserID: userID,
+		Plan:   planChirpyRed,
+	})
+}
+
+// expireSubscriptions marks lapsed subscriptions as expired every interval
+// until ctx is done. Several replicas may run it at once; each expired
+// subscription is only claimed by one of them.
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := cfg.expireLapsedSubscriptions(ctx)
+		if err != nil {
+			log.Printf("Error expiring subscriptions: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) expireLapsedSubscriptions(ctx context.Context) error {
+	tx, err := cfg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	expired, err := qtx.ExpireLapsedSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, subscription := range expired {
+		err = recordSystemAuditTx(ctx, qtx, auditEvent{
+			Action:   "subscription.expired",
+			Target:   subscription.UserID.String(),
+			Metadata: map[string]string{"plan": subscription.Plan, "source": subscription.Source},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -1,16 +1,32 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/revocation"
 )
 
+const (
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 60 * 24 * time.Hour
+)
+
+// refreshToken rotates the presented refresh token: the old one is revoked
+// and a new one in the same session is returned with the access token. The
+// tokens of a session form a family, so a revoked token coming back means it
+// was copied and the whole session is shut down.
 func (cfg *apiConfig) refreshToken(w http.ResponseWriter, r *http.Request) {
 
 	type parameters struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -20,31 +36,127 @@ func (cfg *apiConfig) refreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tokenDB, err := cfg.db.GetUserFromRefreshToken(r.Context(), refreshToken)
+	tokenDB, err := cfg.db.GetUserFromRefreshToken(r.Context(), auth.HashToken(refreshToken))
 
-	if err != nil || tokenDB.RevokedAt.Valid || time.Now().After(tokenDB.ExpiresAt) {
+	if err != nil || time.Now().After(tokenDB.ExpiresAt) {
 		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
 		return
 	}
 
-	const hour int = 3600 * 1000000000
+	if tokenDB.RevokedAt.Valid {
+		cfg.refreshTokenReused(w, r, tokenDB)
+		return
+	}
+
+	userDB, err := cfg.db.GetUserByID(r.Context(), tokenDB.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+	if userDB.SuspendedAt.Valid {
+		respondWithError(w, http.StatusForbidden, "Account suspended.", nil)
+		return
+	}
+
+	newRefreshToken, err := auth.MakeRefreshToken()
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	revoked, err := qtx.RevokeRefreshToken(r.Context(), auth.HashToken(refreshToken))
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+	if revoked == 0 {
+		// Another request rotated this token since we read it.
+		tx.Rollback()
+		cfg.refreshTokenReused(w, r, tokenDB)
+		return
+	}
+
+	_, err = qtx.CreateRefToken(r.Context(),
+		database.CreateRefTokenParams{
+			TokenHash: auth.HashToken(newRefreshToken),
+			UserID:    tokenDB.UserID,
+			ExpiresAt: time.Now().Add(refreshTokenTTL),
+			SessionID: tokenDB.SessionID})
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+
+	err = qtx.TouchSession(r.Context(), database.TouchSessionParams{
+		ID:        tokenDB.SessionID,
+		UserAgent: r.UserAgent(),
+		IpAddress: clientIP(r),
+	})
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't update session.", err)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:  "token.refreshed",
+		ActorID: uuid.NullUUID{UUID: tokenDB.UserID, Valid: true},
+		Target:  tokenDB.SessionID.String(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
 
 	token, err := auth.MakeJWT(
+		cfg.jwtCfg,
 		tokenDB.UserID,
-		cfg.secret,
-		time.Duration(hour))
+		tokenDB.SessionID,
+		userDB.Role,
+		auth.ScopesForRole(userDB.Role),
+		accessTokenTTL)
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
 		return
 	}
 
-	params := parameters{Token: token}
+	params := parameters{Token: token, RefreshToken: newRefreshToken}
 
 	respondWithJSON(w, http.StatusOK, params)
 
 }
 
+func (cfg *apiConfig) refreshTokenReused(w http.ResponseWriter, r *http.Request, tokenDB database.RefreshToken) {
+	_, err := cfg.revokeSession(r, tokenDB.UserID, tokenDB.SessionID, auditEvent{
+		Action:  "token.reuse_detected",
+		ActorID: uuid.NullUUID{UUID: tokenDB.UserID, Valid: true},
+		Target:  tokenDB.SessionID.String(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't revoke token", err)
+		return
+	}
+	respondWithError(w, http.StatusUnauthorized, "invalid token", nil)
+}
+
 func (cfg *apiConfig) revokeRefreshToken(w http.ResponseWriter, r *http.Request) {
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -54,7 +166,18 @@ func (cfg *apiConfig) revokeRefreshToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = cfg.db.RevokeRefreshToken(r.Context(), refreshToken)
+	tokenDB, err := cfg.db.GetUserFromRefreshToken(r.Context(), auth.HashToken(refreshToken))
+
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid token", err)
+		return
+	}
+
+	_, err = cfg.revokeSession(r, tokenDB.UserID, tokenDB.SessionID, auditEvent{
+		Action:  "session.revoked",
+		ActorID: uuid.NullUUID{UUID: tokenDB.UserID, Valid: true},
+		Target:  tokenDB.SessionID.String(),
+	})
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "couldn't revoke token", err)
@@ -63,3 +186,74 @@ func (cfg *apiConfig) revokeRefreshToken(w http.ResponseWriter, r *http.Request)
 
 	respondWithJSON(w, http.StatusNoContent, "")
 }
+
+var (
+	errInvalidToken = errors.New("invalid token")
+	errTokenRevoked = fmt.Errorf("%w: token has been revoked", errInvalidToken)
+)
+
+// validateAccessToken checks an access token's signature and claims, and
+// that neither the token, its session nor all of its user's tokens were
+// revoked after it was issued. Rejections wrap errInvalidToken; any other
+// error means the check itself failed.
+func (cfg *apiConfig) validateAccessToken(ctx context.Context, tokenString string) (auth.Claims, error) {
+	claims, err := auth.ValidateJWT(tokenString, cfg.jwtCfg)
+	if err != nil {
+		return auth.Claims{}, fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+
+	ids := []string{"jti:" + claims.TokenID}
+	if claims.SessionID != uuid.Nil {
+		ids = append(ids, "sid:"+claims.SessionID.String())
+	}
+	for _, id := range ids {
+		revoked, err := cfg.denylist.IsRevoked(ctx, id)
+		if err != nil {
+			return auth.Claims{}, err
+		}
+		if revoked {
+			return auth.Claims{}, errTokenRevoked
+		}
+	}
+
+	cutoff, err := cfg.denylist.UserCutoff(ctx, claims.UserID)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+	if revocation.IssuedBeforeCutoff(claims.IssuedAt, cutoff) {
+		return auth.Claims{}, errTokenRevoked
+	}
+	return claims, nil
+}
+
+// logout ends the session of the presented access token and refuses the
+// token itself straight away.
+func (cfg *apiConfig) logout(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	err := cfg.denylist.Revoke(r.Context(), "jti:"+principal.TokenID, principal.ExpiresAt)
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "couldn't revoke token", err)
+		return
+	}
+
+	ev := auditEvent{
+		Action:  "session.logout",
+		ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:  principal.SessionID.String(),
+	}
+	if principal.SessionID != uuid.Nil {
+		_, err = cfg.revokeSession(r, principal.UserID, principal.SessionID, ev)
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "couldn't revoke session", err)
+			return
+		}
+	} else {
+		cfg.recordAudit(r, ev)
+	}
+
+	respondWithJSON(w, http.StatusNoContent, "")
+}
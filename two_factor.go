@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+const (
+	totpIssuer          = "Chirpy"
+	challengeLifetime   = 5 * time.Minute
+	recoveryCodeCount   = 10
+	secondFactorFailMsg = "Incorrect two-factor code."
+)
+
+func (cfg *apiConfig) requireSecondFactor(w http.ResponseWriter, r *http.Request, userDB database.User) {
+	challenge, err := auth.MakeChallengeJWT(cfg.jwtCfg, userDB.ID, challengeLifetime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+
+	type response struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		TwoFactorRequired: true,
+		ChallengeToken:    challenge,
+	})
+}
+
+func (cfg *apiConfig) loginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid two-factor request.", err)
+		return
+	}
+
+	userID, err := auth.ValidateChallengeJWT(params.ChallengeToken, cfg.jwtCfg)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "invalid challenge token", err)
+		return
+	}
+
+	limiterKey := "2fa:" + userID.String()
+	attempt, err := cfg.loginLimiter.Attempt(r.Context(), limiterKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't log in right now.", err)
+		return
+	}
+	if attempt.Wait > 0 {
+		respondWithRetryAfter(w, attempt.Wait, "Too many failed login attempts, try again later.")
+		return
+	}
+
+	userDB, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil || !userDB.TotpEnabled {
+		respondWithError(w, http.StatusUnauthorized, "invalid challenge token", err)
+		return
+	}
+
+	ok := false
+	if params.RecoveryCode != "" {
+		used, err := cfg.db.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(strings.ToLower(strings.TrimSpace(params.RecoveryCode))),
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check recovery code, database error.", err)
+			return
+		}
+		ok = used == 1
+	} else {
+		step, valid := auth.ValidateTOTP(params.Code, userDB.TotpSecret.String, time.Now())
+		if valid {
+			// A code is good for one login only.
+			used, err := cfg.db.UseTOTPStep(r.Context(), database.UseTOTPStepParams{
+				UserID:   userID,
+				LastStep: step,
+			})
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't check code, database error.", err)
+				return
+			}
+			ok = used == 1
+		}
+	}
+	if !ok {
+		cfg.loginFailed(w, r, userDB.Email, attempt, secondFactorFailMsg, nil)
+		return
+	}
+
+	err = cfg.loginLimiter.Reset(r.Context(), limiterKey)
+	if err != nil {
+		log.Printf("Error resetting login attempts: %s", err)
+	}
+	cfg.issueSession(w, r, userDB)
+}
+
+func (cfg *apiConfig) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
+
+	userDB, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+	if userDB.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled.", nil)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create secret.", err)
+		return
+	}
+
+	err = cfg.db.SetTOTPSecret(r.Context(), database.SetTOTPSecretParams{
+		ID:         userID,
+		TotpSecret: nullString(secret),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret, database error.", err)
+		return
+	}
+
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+	respondWithJSON(w, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, userDB.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
+
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid code.", err)
+		return
+	}
+
+	userDB, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
+		return
+	}
+	if userDB.TotpEnabled {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already enabled.", nil)
+		return
+	}
+	if !userDB.TotpSecret.Valid {
+		respondWithError(w, http.StatusBadRequest, "Two-factor enrollment hasn't been started.", nil)
+		return
+	}
+	step, ok := auth.ValidateTOTP(params.Code, userDB.TotpSecret.String, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, secondFactorFailMsg, nil)
+		return
+	}
+
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create recovery codes.", err)
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.DeleteRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication, database error.", err)
+		return
+	}
+	for _, code := range codes {
+		err = qtx.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			CodeHash: auth.HashToken(code),
+			UserID:   userID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication, database error.", err)
+			return
+		}
+	}
+	// The code that confirmed enrollment can't be used to log in.
+	used, err := qtx.UseTOTPStep(r.Context(), database.UseTOTPStepParams{
+		UserID:   userID,
+		LastStep: step,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication, database error.", err)
+		return
+	}
+	if used == 0 {
+		respondWithError(w, http.StatusUnauthorized, secondFactorFailMsg, nil)
+		return
+	}
+	err = qtx.EnableTOTP(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication, database error.", err)
+		return
+	}
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:  "2fa.enabled",
+		ActorID: uuid.NullUUID{UUID: userID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication, database error.", err)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't enable two-factor authentication, database error.", err)
+		return
+	}
+
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	respondWithJSON(w, http.StatusOK, response{RecoveryCodes: codes})
+}
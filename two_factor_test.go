@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+func TestUseTOTPStep(t *testing.T) {
+	cfg := testConfig(t)
+	ctx := context.Background()
+
+	userDB, err := cfg.db.CreateUser(ctx, database.CreateUserParams{
+		Email:          "totp-" + uuid.NewString() + "@example.com",
+		HashedPassword: unusablePassword,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleteUserOnCleanup(t, cfg, userDB)
+
+	// The RFC 6238 key, whose code at 1111111111 is 050471 and 30 seconds
+	// earlier 081804. Both are inside the window at 1111111111.
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	now := time.Unix(1111111111, 0)
+	current, ok := auth.ValidateTOTP("050471", secret, now)
+	if !ok {
+		t.Fatal("ValidateTOTP() refused the current code")
+	}
+	previous, ok := auth.ValidateTOTP("081804", secret, now)
+	if !ok {
+		t.Fatal("ValidateTOTP() refused the previous code")
+	}
+
+	steps := []struct {
+		name string
+		step int64
+		want int64
+	}{
+		{name: "first use", step: current, want: 1},
+		{name: "same step again", step: current, want: 0},
+		{name: "earlier step in the window", step: previous, want: 0},
+		{name: "next step", step: current + 1, want: 1},
+	}
+
+	// In order: each step depends on the ones used before it.
+	for _, s := range steps {
+		used, err := cfg.db.UseTOTPStep(ctx, database.UseTOTPStepParams{
+			UserID:   userDB.ID,
+			LastStep: s.step,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != s.want {
+			t.Errorf("%s: UseTOTPStep() = %d, want %d", s.name, used, s.want)
+		}
+	}
+}
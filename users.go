@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/romusking/chirpy/internal/auth"
 	"github.com/romusking/chirpy/internal/database"
+	"github.com/romusking/chirpy/internal/limiter"
 )
 
 type User struct {
@@ -18,6 +23,7 @@ type User struct {
 	Token        string    `json:"token"`
 	RefreshToken string    `json:"refresh_token"`
 	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Role         string    `json:"role"`
 }
 
 func (cfg *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
@@ -39,22 +45,40 @@ func (cfg *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userDB, err := cfg.db.CreateUser(
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create user, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userDB, err := qtx.CreateUser(
 		r.Context(),
 		database.CreateUserParams{
 			Email:          params.Email,
 			HashedPassword: hashed})
 
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create user, database error.", err)
+		return
+	}
+	err = publishUserCreatedTx(r.Context(), qtx, userDB)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create user, database error.", err)
+		return
+	}
+	err = tx.Commit()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create user, database error.", err)
 		return
 	}
 	user := User{
-		ID:          userDB.ID,
-		CreatedAt:   userDB.CreatedAt,
-		UpdatedAt:   userDB.UpdatedAt,
-		Email:       userDB.Email,
-		IsChirpyRed: userDB.IsChirpyRed.Bool,
+		ID:        userDB.ID,
+		CreatedAt: userDB.CreatedAt,
+		UpdatedAt: userDB.UpdatedAt,
+		Email:     userDB.Email,
+		Role:      userDB.Role,
 	}
 	respondWithJSON(w, 201, user)
 
@@ -65,7 +89,30 @@ func (cfg *apiConfig) resetUserDB(w http.ResponseWriter, req *http.Request) {
 		respondWithError(w, http.StatusForbidden, "Can't remove all users", nil)
 		return
 	}
-	err := cfg.db.DeleteAllUsers(req.Context())
+	principal, _ := auth.PrincipalFromContext(req.Context())
+
+	tx, err := cfg.conn.BeginTx(req.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't delete users, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	err = qtx.DeleteAllUsers(req.Context())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't delete users, database error.", err)
+		return
+	}
+	err = recordAuditTx(qtx, req, auditEvent{
+		Action:  "admin.users_reset",
+		ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't delete users, database error.", err)
+		return
+	}
+	err = tx.Commit()
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Can't delete users, database error.", err)
 		return
@@ -77,6 +124,16 @@ func (cfg *apiConfig) resetUserDB(w http.ResponseWriter, req *http.Request) {
 
 }
 
+// publishUserCreatedTx writes user.created for a user created with qtx,
+// however they signed up.
+func publishUserCreatedTx(ctx context.Context, qtx *database.Queries, userDB database.User) error {
+	return publishEventTx(ctx, qtx, eventUserCreated, userDB.ID, map[string]any{
+		"id":         userDB.ID,
+		"email":      userDB.Email,
+		"created_at": userDB.CreatedAt,
+	})
+}
+
 func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 
 	type parameters struct {
@@ -91,43 +148,143 @@ func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password.", err)
 		return
 	}
-	userDB, err := cfg.db.GetUserPassword(r.Context(), params.Email)
 
+	emailKey := "email:" + strings.ToLower(params.Email)
+	ipKey := "ip:" + clientIP(r)
+	limiterKeys := []string{emailKey, ipKey}
+
+	attempt, err := cfg.loginLimiter.Attempt(r.Context(), limiterKeys...)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password.", err)
+		respondWithError(w, http.StatusInternalServerError, "Can't log in right now.", err)
+		return
+	}
+	if attempt.Wait > 0 {
+		cfg.recordAudit(r, auditEvent{
+			Action: "login.throttled",
+			Target: params.Email,
+		})
+		respondWithRetryAfter(w, attempt.Wait, "Too many failed login attempts, try again later.")
+		return
+	}
+
+	userDB, err := cfg.db.GetUserPassword(r.Context(), params.Email)
+	if err != nil || userDB.HashedPassword == unusablePassword {
+		// Take as long as a wrong password, so that the response time
+		// doesn't tell which emails are registered.
+		auth.CheckPasswordHash(params.Password, dummyPasswordHash())
+		cfg.loginFailed(w, r, params.Email, attempt, "Incorrect email or password.", err)
 		return
 	}
 	err = auth.CheckPasswordHash(params.Password, userDB.HashedPassword)
+	if err != nil {
+		cfg.loginFailed(w, r, params.Email, attempt, "Incorrect email or password.", err)
+		return
+	}
 
+	// The IP's earlier failures may be guesses at other accounts, which
+	// logging into this one mustn't clear. Only this attempt is taken
+	// back, so that users sharing an address aren't locked out by their
+	// own logins.
+	err = cfg.loginLimiter.Reset(r.Context(), emailKey)
+	if err == nil {
+		err = cfg.loginLimiter.Forgive(r.Context(), ipKey)
+	}
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password.", err)
+		log.Printf("Error resetting login attempts: %s", err)
+	}
+	cfg.finishLogin(w, r, userDB)
+
+}
+
+// finishLogin is reached once the user proved who they are, by password or
+// through an identity provider, and asks for the second factor if needed.
+func (cfg *apiConfig) finishLogin(w http.ResponseWriter, r *http.Request, userDB database.User) {
+	if userDB.SuspendedAt.Valid {
+		respondWithError(w, http.StatusForbidden, "Account suspended.", nil)
 		return
 	}
-	const hour int = 3600 * 1000000000
-	const exp int = hour * 24 * 60
+	if userDB.TotpEnabled {
+		cfg.requireSecondFactor(w, r, userDB)
+		return
+	}
+	cfg.issueSession(w, r, userDB)
+}
 
-	token, err := auth.MakeJWT(
-		userDB.ID,
-		cfg.secret,
-		time.Duration(hour))
+// issueSession hands out the access and refresh token pair once a user has
+// fully authenticated.
+func (cfg *apiConfig) issueSession(w http.ResponseWriter, r *http.Request, userDB database.User) {
+	if userDB.SuspendedAt.Valid {
+		respondWithError(w, http.StatusForbidden, "Account suspended.", nil)
+		return
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
 		return
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
-
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	session, err := qtx.CreateSession(r.Context(),
+		database.CreateSessionParams{
+			UserAgent: r.UserAgent(),
+			IpAddress: clientIP(r),
+			UserID:    userDB.ID})
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create session.", err)
+		return
+	}
 
-	_, err = cfg.db.CreateRefToken(r.Context(),
+	_, err = qtx.CreateRefToken(r.Context(),
 		database.CreateRefTokenParams{
-			Token:     refreshToken,
+			TokenHash: auth.HashToken(refreshToken),
 			UserID:    userDB.ID,
-			ExpiresAt: time.Now().Add(time.Duration(exp))})
+			ExpiresAt: time.Now().Add(refreshTokenTTL),
+			SessionID: session.ID})
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:  "login.succeeded",
+		ActorID: uuid.NullUUID{UUID: userDB.ID, Valid: true},
+		Target:  session.ID.String(),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
+		return
+	}
+
+	isRed, err := cfg.isChirpyRed(r.Context(), userDB.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check subscription.", err)
+		return
+	}
+
+	token, err := auth.MakeJWT(
+		cfg.jwtCfg,
+		userDB.ID,
+		session.ID,
+		userDB.Role,
+		auth.ScopesForRole(userDB.Role),
+		accessTokenTTL)
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Can't create token.", err)
@@ -141,26 +298,43 @@ func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 		Email:        userDB.Email,
 		Token:        token,
 		RefreshToken: refreshToken,
-		IsChirpyRed:  userDB.IsChirpyRed.Bool,
+		IsChirpyRed:  isRed,
+		Role:         userDB.Role,
 	}
 	respondWithJSON(w, http.StatusOK, user)
 
 }
 
-func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
-
-	token, err := auth.GetBearerToken(r.Header)
-
+// dummyPasswordHash is checked instead of a user's hash when there is
+// none to check.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := auth.HashPassword("chirpy-dummy-password")
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "no auth token in request", err)
-		return
+		log.Printf("Error hashing dummy password: %s", err)
 	}
-	userID, err := auth.ValidateJWT(token, cfg.secret)
+	return hash
+})
 
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "invalid token in request", err)
+func (cfg *apiConfig) loginFailed(w http.ResponseWriter, r *http.Request, email string, attempt limiter.Result, msg string, err error) {
+	action := "login.failed"
+	if attempt.Locked {
+		action = "login.locked"
+	}
+	cfg.recordAudit(r, auditEvent{
+		Action: action,
+		Target: email,
+	})
+	if attempt.Locked {
+		respondWithRetryAfter(w, attempt.RetryAfter, "Too many failed login attempts, try again later.")
 		return
 	}
+	respondWithError(w, http.StatusUnauthorized, msg, err)
+}
+
+func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	userID := principal.UserID
 
 	type parameters struct {
 		Email    string `json:"email"`
@@ -169,7 +343,7 @@ func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Can't update user, invalid email or password.", err)
 		return
@@ -181,7 +355,15 @@ func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userDB, err := cfg.db.UpdateUserDetails(
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user, database error.", err)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userDB, err := qtx.UpdateUserDetails(
 		r.Context(),
 		database.UpdateUserDetailsParams{
 			ID:             userID,
@@ -192,49 +374,51 @@ func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update user, database error.", err)
 		return
 	}
-	user := User{
-		ID:          userDB.ID,
-		CreatedAt:   userDB.CreatedAt,
-		UpdatedAt:   userDB.UpdatedAt,
-		Email:       userDB.Email,
-		IsChirpyRed: userDB.IsChirpyRed.Bool,
-	}
-	respondWithJSON(w, http.StatusOK, user)
-
-}
-
-func (cfg *apiConfig) makeUserRed(w http.ResponseWriter, r *http.Request) {
-	apiKey, err := auth.GetAPIKey(r.Header)
 
-	if err != nil || apiKey != cfg.polkaKey {
-		respondWithError(w, http.StatusUnauthorized, "Can't authenticate", err)
+	// Sessions started with the old password, and their refresh tokens,
+	// end with it.
+	err = revokeAllSessionsTx(r.Context(), qtx, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions.", err)
 		return
 	}
 
-	type data struct {
-		UserID uuid.UUID `json:"user_id"`
+	err = recordAuditTx(qtx, r, auditEvent{
+		Action:  "user.password_changed",
+		ActorID: uuid.NullUUID{UUID: userID, Valid: true},
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user, database error.", err)
+		return
 	}
-	type parameters struct {
-		Event string `json:"event"`
-		Data  data   `json:"data"`
+
+	err = tx.Commit()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user, database error.", err)
+		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	// Tokens issued with the old password must not outlive it.
+	err = cfg.denylist.RevokeUserBefore(r.Context(), userID, time.Now())
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "No identifiable data", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke old tokens.", err)
 		return
 	}
-	if params.Event == "user.upgraded" {
-		_, err = cfg.db.MakeUserRed(r.Context(), params.Data.UserID)
-		if err != nil {
-			respondWithError(w, http.StatusNotFound, "User doesn't exist.", err)
-			return
-		}
 
+	isRed, err := cfg.isChirpyRed(r.Context(), userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check subscription.", err)
+		return
 	}
 
-	respondWithJSON(w, http.StatusNoContent, "")
+	user := User{
+		ID:          userDB.ID,
+		CreatedAt:   userDB.CreatedAt,
+		UpdatedAt:   userDB.UpdatedAt,
+		Email:       userDB.Email,
+		IsChirpyRed: isRed,
+		Role:        userDB.Role,
+	}
+	respondWithJSON(w, http.StatusOK, user)
 
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romusking/chirpy/internal/auth"
+	"github.com/romusking/chirpy/internal/database"
+)
+
+// Statuses of a received webhook event.
+const (
+	webhookEventReceived  = "received"
+	webhookEventProcessed = "processed"
+	webhookEventIgnored   = "ignored"
+	webhookEventFailed    = "failed"
+)
+
+type WebhookEvent struct {
+	ID          uuid.UUID       `json:"id"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at"`
+	Source      string          `json:"source"`
+	EventID     string          `json:"event_id"`
+	EventType   string          `json:"event_type"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+func webhookEventDBToJSON(eventDB database.WebhookEvent) WebhookEvent {
+	event := WebhookEvent{
+		ID:         eventDB.ID,
+		ReceivedAt: eventDB.ReceivedAt,
+		Source:     eventDB.Source,
+		EventID:    eventDB.EventID,
+		EventType:  eventDB.EventType,
+		Status:     eventDB.Status,
+		Attempts:   eventDB.Attempts,
+		LastError:  eventDB.LastError.String,
+		Payload:    eventDB.Payload,
+	}
+	if eventDB.ProcessedAt.Valid {
+		event.ProcessedAt = &eventDB.ProcessedAt.Time
+	}
+	return event
+}
+
+// adminListWebhookEvents lists received webhook events, newest first,
+// optionally only those with the status query parameter.
+func (cfg *apiConfig) adminListWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := pageParams(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	eventsInDB, err := cfg.db.ListWebhookEvents(r.Context(), database.ListWebhookEventsParams{
+		Status: nullString(r.URL.Query().Get("status")),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook events, database error.", err)
+		return
+	}
+
+	events := make([]WebhookEvent, len(eventsInDB))
+	for i, eventDB := range eventsInDB {
+		events[i] = webhookEventDBToJSON(eventDB)
+	}
+	respondWithJSON(w, http.StatusOK, events)
+}
+
+// adminReplayWebhookEvent processes a failed event again, for example once
+// the user it refers to exists. Events left unfinished by a crash can be
+// replayed too.
+func (cfg *apiConfig) adminReplayWebhookEvent(w http.ResponseWriter, r *http.Request) {
+
+	principal, _ := auth.PrincipalFromContext(r.Context())
+
+	eventID, err := uuid.Parse(r.PathValue("eventID"))
+
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't replay event, wrong UUID.", err)
+		return
+	}
+
+	eventDB, err := cfg.db.GetWebhookEventByID(r.Context(), eventID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Event doesn't exist.", err)
+		return
+	}
+	if eventDB.Status != webhookEventFailed && eventDB.Status != webhookEventReceived {
+		respondWithError(w, http.StatusConflict, "Only failed or unfinished events can be replayed.", nil)
+		return
+	}
+
+	cfg.recordAudit(r, auditEvent{
+		Action:  "admin.webhook_replayed",
+		ActorID: uuid.NullUUID{UUID: principal.UserID, Valid: true},
+		Target:  eventID.String(),
+	})
+
+	// The outcome is stored on the event, which is returned either way.
+	_ = cfg.processPolkaEvent(r, eventDB)
+
+	eventDB, err = cfg.db.GetWebhookEventByID(r.Context(), eventID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get event, database error.", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, webhookEventDBToJSON(eventDB))
+}